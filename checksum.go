@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"gopkg.in/yaml.v2"
+	"sort"
+	"strings"
+)
+
+const CONFIG_CHECKSUM_ANNOTATION = "kube-deployer/config-checksum"
+
+/*
+ * Stamps a checksum of all rendered ConfigMaps and Secrets a workload
+ * references onto its pod template, so a config change rolls the workload.
+ * Works on the rendered definition because the data of ConfigMaps and Secrets
+ * is usually templated. The definition is returned as is if no workload
+ * references a rendered ConfigMap or Secret.
+ */
+func injectConfigChecksums(definition string) (string, error) {
+	objects, err := UnmarshalYaml(definition)
+
+	if err != nil {
+		return "", err
+	}
+
+	checksums := map[string]string{}
+
+	for _, object := range objects {
+		kind := NestedString(object, "kind")
+
+		if kind != K8S_CONFIGMAP && kind != K8S_SECRET {
+			continue
+		}
+
+		data, err := yaml.Marshal(map[string]interface{}{
+			"data":       object["data"],
+			"binaryData": object["binaryData"],
+			"stringData": object["stringData"],
+		})
+
+		if err != nil {
+			return "", err
+		}
+
+		checksums[kind+"/"+NestedString(object, "metadata", "name")] = sha256Hex(data)
+	}
+
+	injected := false
+
+	for _, object := range objects {
+		podTemplateMetadata, ok := podTemplateOf(object, "metadata").(map[interface{}]interface{})
+
+		if !ok {
+			continue
+		}
+
+		configChecksums := make([]string, 0)
+
+		for _, reference := range podSpecReferences(podTemplateOf(object, "spec")) {
+			key := reference.kind + "/" + reference.Name()
+
+			if checksum, ok := checksums[key]; ok {
+				configChecksums = append(configChecksums, key+"="+checksum)
+			}
+		}
+
+		if len(configChecksums) == 0 {
+			continue
+		}
+
+		configChecksums = Unique(configChecksums)
+		sort.Strings(configChecksums)
+
+		annotations := injectChildMap(podTemplateMetadata, "annotations")
+		annotations[CONFIG_CHECKSUM_ANNOTATION] = sha256Hex([]byte(strings.Join(configChecksums, "\n")))
+		injected = true
+	}
+
+	if !injected {
+		return definition, nil
+	}
+
+	templates := make([]string, 0)
+
+	for _, object := range objects {
+		template, err := yaml.Marshal(object)
+
+		if err != nil {
+			return "", err
+		}
+
+		templates = append(templates, string(template))
+	}
+
+	return strings.Join(templates, "\n---\n"), nil
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+const testChecksumTemplate = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  IMAGE: "{{ context.containers.php.name }}"
+
+---
+
+apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+stringData:
+  password: secret
+
+---
+
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+        - name: php
+          image: "{{ context.containers.php.name }}"
+          envFrom:
+            - configMapRef:
+                name: config
+      volumes:
+        - name: credentials
+          secret:
+            secretName: credentials
+
+---
+
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+spec:
+  template:
+    metadata:
+      labels:
+        app: worker
+    spec:
+      containers:
+        - name: php
+          image: "{{ context.containers.php.name }}"
+`
+
+func renderChecksums(t *testing.T, deployerSpec DeployerSpec) map[string]string {
+	definition, err := render(deployerSpec)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := UnmarshalYaml(definition)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checksums := map[string]string{}
+
+	for _, object := range objects {
+		checksums[NestedString(object, "metadata", "name")] = NestedString(object, "spec", "template", "metadata", "annotations", CONFIG_CHECKSUM_ANNOTATION)
+	}
+
+	return checksums
+}
+
+func TestInjectConfigChecksums(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{"app.yml": testChecksumTemplate})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+	deployerSpec := testDeployerSpec(projectDir, "master")
+
+	checksums := renderChecksums(t, deployerSpec)
+
+	assert.Len(checksums["master-web"], 64)
+	assert.Equal("", checksums["master-worker"])
+	assert.Equal(checksums, renderChecksums(t, deployerSpec))
+
+	deployerSpec.TagVersion = "43"
+
+	assert.NotEqual(checksums["master-web"], renderChecksums(t, deployerSpec)["master-web"])
+}
+
+func TestInjectConfigChecksumsKeepsDefinition(t *testing.T) {
+	definition := "kind: Service\nmetadata:\n  name: web\n"
+
+	output, err := injectConfigChecksums(definition)
+
+	assert.Nil(t, err)
+	assert.Equal(t, definition, output)
+}
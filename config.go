@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var indentedLine = regexp.MustCompile(`(?m:^( +)[^ #\n])`)
+var typeErrorLine = regexp.MustCompile(`^line (\d+):`)
+
+/*
+ * Parses the config file and migrates it to the current spec version. The
+ * returned mapping node keeps the lines of the file.
+ */
+func ParseConfig(content []byte) (*yaml3.Node, error) {
+	node, err := parseConfigNode(content)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return node, MigrateConfig(node)
+}
+
+/*
+ * Returns the config file rewritten in the current spec version, nil if it
+ * already is.
+ */
+func MigrateConfigFile(content []byte) ([]byte, error) {
+	node, err := parseConfigNode(content)
+
+	if err != nil || !isLegacyConfig(node) {
+		return nil, err
+	}
+
+	err = MigrateConfig(node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return FormatConfig(node, content)
+}
+
+func parseConfigNode(content []byte) (*yaml3.Node, error) {
+	var document yaml3.Node
+	err := yaml3.Unmarshal(content, &document)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml3.MappingNode {
+		return nil, fmt.Errorf("%s must be a map", DEFAULT_DEPLOYER_YAML)
+	}
+
+	node := document.Content[0]
+
+	return node, checkConfigVersion(configVersion(node))
+}
+
+/*
+ * Decodes a parsed config node with the yaml.v2 rules of the values files and
+ * templates, e.g. a var debug: no is false. Type errors carry the lines of
+ * the node, not of the document it was encoded to.
+ */
+func DecodeConfig(node *yaml3.Node, out interface{}) error {
+	content, err := yaml3.Marshal(node)
+
+	if err != nil {
+		return err
+	}
+
+	err = yaml.Unmarshal(content, out)
+	typeError, ok := err.(*yaml.TypeError)
+
+	if !ok {
+		return err
+	}
+
+	var encoded yaml3.Node
+
+	if yaml3.Unmarshal(content, &encoded) != nil || len(encoded.Content) == 0 {
+		return err
+	}
+
+	lines := map[int]int{}
+	mapConfigLines(encoded.Content[0], node, lines)
+
+	for i, message := range typeError.Errors {
+		typeError.Errors[i] = typeErrorLine.ReplaceAllStringFunc(message, func(prefix string) string {
+			line, _ := strconv.Atoi(typeErrorLine.FindStringSubmatch(prefix)[1])
+
+			return fmt.Sprintf("line %d:", lines[line])
+		})
+	}
+
+	return typeError
+}
+
+/*
+ * Maps the lines of an encoded node to the lines of the node it was encoded
+ * from, both trees have the same shape.
+ */
+func mapConfigLines(encoded *yaml3.Node, original *yaml3.Node, lines map[int]int) {
+	if _, ok := lines[encoded.Line]; !ok {
+		lines[encoded.Line] = original.Line
+	}
+
+	for i := 0; i < len(encoded.Content) && i < len(original.Content); i++ {
+		mapConfigLines(encoded.Content[i], original.Content[i], lines)
+	}
+}
+
+func configVersion(node *yaml3.Node) int {
+	versionNode := valueOf(node, "version")
+
+	if versionNode == nil {
+		return 0
+	}
+
+	version, _ := strconv.Atoi(versionNode.Value)
+
+	return version
+}
+
+func checkConfigVersion(version int) error {
+	if version < DEPLOYER_SPEC_MIN_VERSION {
+		return fmt.Errorf("%s Spec must have at least version %d", DEFAULT_DEPLOYER_YAML, DEPLOYER_SPEC_MIN_VERSION)
+	}
+
+	return nil
+}
+
+/*
+ * Before version 2 any version from 1 on was accepted (and version 3 was
+ * asked for), so older configs are recognized by their lists of containers or
+ * targets, not by their version.
+ */
+func isLegacyConfig(node *yaml3.Node) bool {
+	if configVersion(node) != DEPLOYER_SPEC_VERSION {
+		return true
+	}
+
+	if containers := valueOf(node, "containers"); containers != nil && containers.Kind == yaml3.SequenceNode {
+		return true
+	}
+
+	if clusters := valueOf(node, "clusters"); clusters != nil && clusters.Kind == yaml3.MappingNode {
+		for i := 1; i < len(clusters.Content); i += 2 {
+			if targets := valueOf(clusters.Content[i], "targets"); targets != nil && targets.Kind == yaml3.SequenceNode {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+/*
+ * Rewrites an older config node into version 2, nodes are moved so their
+ * comments and lines are kept:
+ *
+ * - containers is a map by id instead of a list of items with an id
+ * - targets of a cluster are a map by namespace instead of a list
+ *
+ * Configs of the current version are left unchanged.
+ */
+func MigrateConfig(node *yaml3.Node) error {
+	if !isLegacyConfig(node) {
+		return nil
+	}
+
+	err := sequenceToMapping(valueOf(node, "containers"), "id", "container")
+
+	if err != nil {
+		return err
+	}
+
+	if clusters := valueOf(node, "clusters"); clusters != nil && clusters.Kind == yaml3.MappingNode {
+		for i := 1; i < len(clusters.Content); i += 2 {
+			err = sequenceToMapping(valueOf(clusters.Content[i], "targets"), "namespace", "target")
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	valueOf(node, "version").Value = strconv.Itoa(DEPLOYER_SPEC_VERSION)
+
+	return nil
+}
+
+/*
+ * Turns a list of maps into a map keyed by the given field of each item.
+ */
+func sequenceToMapping(node *yaml3.Node, keyField string, itemName string) error {
+	if node == nil || node.Kind != yaml3.SequenceNode {
+		return nil
+	}
+
+	items := node.Content
+	keys := map[string]bool{}
+	content := make([]*yaml3.Node, 0, 2*len(items))
+
+	for _, item := range items {
+		if item.Kind != yaml3.MappingNode || valueOf(item, keyField) == nil {
+			return fmt.Errorf("line %d: %s without %s", item.Line, itemName, keyField)
+		}
+
+		var keyNode *yaml3.Node
+		fields := make([]*yaml3.Node, 0, len(item.Content))
+
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			if item.Content[i].Value == keyField {
+				keyNode = item.Content[i+1]
+			} else {
+				fields = append(fields, item.Content[i], item.Content[i+1])
+			}
+		}
+
+		if keys[keyNode.Value] {
+			return fmt.Errorf("line %d: duplicate %s %s", keyNode.Line, itemName, keyNode.Value)
+		}
+
+		keys[keyNode.Value] = true
+
+		keyNode.HeadComment = item.HeadComment
+		keyNode.Style = 0
+		item.HeadComment = ""
+		item.Content = fields
+
+		if len(fields) == 0 {
+			item.Style = yaml3.FlowStyle
+		}
+
+		content = append(content, keyNode, item)
+	}
+
+	node.Kind = yaml3.MappingNode
+	node.Tag = "!!map"
+	node.Style = 0
+	node.Content = content
+
+	return nil
+}
+
+/*
+ * Formats a config node with the indentation of the original file.
+ */
+func FormatConfig(node *yaml3.Node, original []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	encoder := yaml3.NewEncoder(&buffer)
+	encoder.SetIndent(configIndent(original))
+
+	err := encoder.Encode(node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return restoreBlankLines(node, original, buffer.Bytes()), nil
+}
+
+/*
+ * The encoder drops blank lines, the ones separating top level keys (and
+ * their head comments) in the original are added again.
+ */
+func restoreBlankLines(node *yaml3.Node, original []byte, formatted []byte) []byte {
+	originalLines := strings.Split(string(original), "\n")
+	separated := map[string]bool{}
+
+	for i := 2; i < len(node.Content); i += 2 {
+		line := node.Content[i].Line - 2
+
+		for line >= 0 && strings.HasPrefix(strings.TrimSpace(originalLines[line]), "#") {
+			line--
+		}
+
+		if line >= 0 && strings.TrimSpace(originalLines[line]) == "" {
+			separated[node.Content[i].Value] = true
+		}
+	}
+
+	lines := strings.Split(string(formatted), "\n")
+	result := make([]string, 0, len(lines))
+	commentStart := -1
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			if commentStart < 0 {
+				commentStart = len(result)
+			}
+		} else if key := strings.SplitN(line, ":", 2)[0]; line != "" && line[0] != ' ' && separated[key] {
+			insertAt := len(result)
+			if commentStart >= 0 {
+				insertAt = commentStart
+			}
+
+			result = append(result[:insertAt], append([]string{""}, result[insertAt:]...)...)
+			commentStart = -1
+		} else {
+			commentStart = -1
+		}
+
+		result = append(result, line)
+	}
+
+	return []byte(strings.Join(result, "\n"))
+}
+
+func configIndent(content []byte) int {
+	indent := 0
+
+	for _, match := range indentedLine.FindAllSubmatch(content, -1) {
+		if indent == 0 || len(match[1]) < indent {
+			indent = len(match[1])
+		}
+	}
+
+	if indent < 2 {
+		return 2
+	}
+
+	return indent
+}
+
+/*
+ * Returns the containers sorted by id, the image and tag of a target
+ * container override the ones of the top level container.
+ */
+func configContainers(containers map[string]DeployerConfigFileContainer, targetContainers map[string]DeployerConfigFileContainer) []DeployerSpecContainer {
+	merged := map[string]DeployerConfigFileContainer{}
+
+	for id, container := range containers {
+		merged[id] = container
+	}
+
+	for id, targetContainer := range targetContainers {
+		container := merged[id]
+
+		if targetContainer.Image != "" {
+			container.Image = targetContainer.Image
+		}
+
+		if targetContainer.Tag != "" {
+			container.Tag = targetContainer.Tag
+		}
+
+		merged[id] = container
+	}
+
+	ids := make([]string, 0, len(merged))
+	for id := range merged {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	specContainers := make([]DeployerSpecContainer, 0, len(ids))
+
+	for _, id := range ids {
+		specContainers = append(specContainers, DeployerSpecContainer{
+			Id:    id,
+			Image: merged[id].Image,
+			Tag:   merged[id].Tag,
+		})
+	}
+
+	return specContainers
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testConfigV1 = `# deployed by ci
+version: 1
+
+containers:
+    # the app
+    - id: php
+      image: foo/bar
+    - id: nginx
+      image: nginx
+
+clusters:
+    de_cluster:
+        host: https://foo.k8s.bar.io
+        targets:
+            # short lived branch envs
+            - namespace: staging
+              ttl: 7d # removed by clean -expired
+              templates:
+                - app.yml
+            - namespace: production
+`
+
+const testConfigV2 = `# deployed by ci
+version: 2
+
+containers:
+    # the app
+    php:
+        image: foo/bar
+    nginx:
+        image: nginx
+
+clusters:
+    de_cluster:
+        host: https://foo.k8s.bar.io
+        targets:
+            # short lived branch envs
+            staging:
+                ttl: 7d # removed by clean -expired
+                templates:
+                    - app.yml
+            production: {}
+`
+
+func TestMigrateConfigFile(t *testing.T) {
+	assert := assert.New(t)
+
+	migrated, err := MigrateConfigFile([]byte(testConfigV1))
+	assert.Nil(err)
+	assert.Equal(testConfigV2, string(migrated))
+
+	migrated, err = MigrateConfigFile([]byte(testConfigV2))
+	assert.Nil(err)
+	assert.Nil(migrated)
+
+	// older releases accepted any version and asked for version 3
+	migrated, err = MigrateConfigFile([]byte(strings.Replace(testConfigV1, "version: 1", "version: 3", 1)))
+	assert.Nil(err)
+	assert.Equal(testConfigV2, string(migrated))
+
+	migrated, err = MigrateConfigFile([]byte(strings.Replace(testConfigV1, "version: 1", "version: 2", 1)))
+	assert.Nil(err)
+	assert.Equal(testConfigV2, string(migrated))
+}
+
+func TestMigrateConfigErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := ParseConfig([]byte("version: 1\ncontainers:\n  - image: foo/bar\n"))
+	assert.EqualError(err, "line 3: container without id")
+
+	_, err = ParseConfig([]byte("version: 1\nclusters:\n  de:\n    targets:\n      - namespace: a\n      - namespace: a\n"))
+	assert.EqualError(err, "line 6: duplicate target a")
+
+	_, err = ParseConfig([]byte("project: foo\n"))
+	assert.EqualError(err, ".kube-deploy.yml Spec must have at least version 1")
+}
+
+func TestConfigVersions(t *testing.T) {
+	testConfigV3 := strings.Replace(testConfigV1, "version: 1", "version: 3", 1)
+
+	for _, config := range []string{testConfigV1, testConfigV2, testConfigV3} {
+		projectDir := writeTestProject(t, map[string]string{DEFAULT_DEPLOYER_YAML: config})
+		defer os.RemoveAll(projectDir)
+
+		var deployerSpec DeployerSpec
+		err := deployerSpec.fromFile(projectDir, "42", "de_cluster", "master", "staging")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"app.yml"}, deployerSpec.Templates)
+		assert.Equal(t, []DeployerSpecContainer{{Id: "nginx", Image: "nginx"}, {Id: "php", Image: "foo/bar"}}, deployerSpec.Containers)
+	}
+}
+
+func TestConfigContainerTags(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		DEFAULT_DEPLOYER_YAML: `version: 2
+containers:
+  php:
+    image: foo/bar
+  nginx:
+    image: nginx
+    tag: "1.25"
+clusters:
+  de:
+    host: https://foo.k8s.bar.io
+    targets:
+      staging:
+        rendering: text
+        templates:
+          - app.yml
+        containers:
+          php:
+            tag: stable
+`,
+		"app.yml": testAppTemplate,
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+
+	var deployerSpec DeployerSpec
+	err := deployerSpec.fromFile(projectDir, "42", "de", "master", "staging")
+	assert.Nil(err)
+	assert.Equal(RENDERING_TEXT, deployerSpec.Rendering)
+
+	deployerSpec.Branch = "master"
+	definition, err := render(deployerSpec)
+
+	assert.Nil(err)
+	assert.Contains(definition, "image: foo/bar:stable")
+}
+
+func TestDecodeConfigVars(t *testing.T) {
+	node, err := ParseConfig([]byte("version: 1\nvars:\n  debug: no\n  replicas: 3\n  tag: \"0123\"\n"))
+	assert.Nil(t, err)
+
+	var deployerConfig DeployerConfigFile
+	err = DecodeConfig(node, &deployerConfig)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, deployerConfig.SpecVersion)
+	assert.Equal(t, map[string]interface{}{"debug": false, "replicas": 3, "tag": "0123"}, deployerConfig.Vars)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v2"
+	"math"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+/*
+ * Renders the definition and compares every object with its live counterpart.
+ * Returns the unified diffs of all changed objects and whether there were any.
+ */
+func diff(kubeClient KubeClient, deployerSpec DeployerSpec) (string, bool, error) {
+	kubernetesDefinition, err := render(deployerSpec)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	objects, err := UnmarshalYaml(kubernetesDefinition)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	diffs := make([]string, 0)
+
+	for _, object := range objects {
+		kind := NestedString(object, "kind")
+		name := NestedString(object, "metadata", "name")
+
+		rendered := NormalizeObject(object)
+		encodeStringData(rendered)
+		stripVolatileAnnotations(rendered)
+
+		liveText := ""
+		liveObject, err := kubeClient.Get(NestedString(object, "apiVersion"), kind, deployerSpec.Namespace, name)
+
+		if err != nil && !IsNotFound(err) {
+			return "", false, err
+		}
+
+		if err == nil {
+			live := StripServerFields(liveObject)
+			liveText, err = marshalForDiff(PruneToTemplate(live, rendered))
+
+			if err != nil {
+				return "", false, err
+			}
+		}
+
+		renderedText, err := marshalForDiff(rendered)
+
+		if err != nil {
+			return "", false, err
+		}
+
+		objectDiff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(liveText),
+			B:        difflib.SplitLines(renderedText),
+			FromFile: fmt.Sprintf("live/%s/%s", kind, name),
+			ToFile:   fmt.Sprintf("rendered/%s/%s", kind, name),
+			Context:  3,
+		})
+
+		if err != nil {
+			return "", false, err
+		}
+
+		if objectDiff != "" {
+			diffs = append(diffs, objectDiff)
+		}
+	}
+
+	return strings.Join(diffs, "\n"), len(diffs) > 0, nil
+}
+
+/*
+ * Drops every map key of the live object which is not set in the template,
+ * so values defaulted or added by the api server do not show up as changes.
+ * Lists are compared item by item and keep their live length.
+ */
+func PruneToTemplate(live interface{}, template interface{}) interface{} {
+	return pruneToTemplate(live, template, false)
+}
+
+/*
+ * Values below these fields are quantities, which the api server
+ * canonicalizes, e.g. 0.5 to 500m.
+ */
+var quantityFields = map[string]bool{
+	"resources": true,
+	"capacity":  true,
+	"hard":      true,
+	"limits":    true,
+	"overhead":  true,
+}
+
+func pruneToTemplate(live interface{}, template interface{}, quantity bool) interface{} {
+	switch typedLive := live.(type) {
+	case map[string]interface{}:
+		typedTemplate, ok := template.(map[string]interface{})
+
+		if !ok {
+			return live
+		}
+
+		pruned := make(map[string]interface{})
+
+		for key, templateValue := range typedTemplate {
+			if liveValue, ok := typedLive[key]; ok {
+				pruned[key] = pruneToTemplate(liveValue, templateValue, quantity || quantityFields[key])
+			}
+		}
+
+		return pruned
+	case []interface{}:
+		typedTemplate, ok := template.([]interface{})
+
+		if !ok {
+			return live
+		}
+
+		pruned := make([]interface{}, len(typedLive))
+
+		for i, liveValue := range typedLive {
+			if i < len(typedTemplate) {
+				pruned[i] = pruneToTemplate(liveValue, typedTemplate[i], quantity)
+			} else {
+				pruned[i] = liveValue
+			}
+		}
+
+		return pruned
+	case float64:
+		// Json numbers are floats, whole numbers are marshalled as 1e+06
+		if typedLive == math.Trunc(typedLive) && math.Abs(typedLive) < 1<<53 {
+			live = int(typedLive)
+		}
+
+		if quantity {
+			return normalizeLiveNumber(live, template)
+		}
+	case string:
+		if !quantity {
+			return live
+		}
+
+		if liveQuantity, ok := parseQuantity(typedLive); ok {
+			templateQuantity, ok := parseQuantity(fmt.Sprint(template))
+
+			if ok && liveQuantity.Cmp(templateQuantity) == 0 {
+				return template
+			}
+		}
+	}
+
+	return live
+}
+
+func normalizeLiveNumber(live interface{}, template interface{}) interface{} {
+	if templateQuantity, ok := parseQuantity(fmt.Sprint(template)); ok {
+		liveQuantity, _ := parseQuantity(fmt.Sprint(live))
+
+		if liveQuantity != nil && liveQuantity.Cmp(templateQuantity) == 0 {
+			return template
+		}
+	}
+
+	return live
+}
+
+var quantityPattern = regexp.MustCompile(`^([+-]?(?:[0-9]+\.?[0-9]*|\.[0-9]+)(?:[eE][+-]?[0-9]+)?)(m|k|M|G|T|P|E|Ki|Mi|Gi|Ti|Pi|Ei)?$`)
+
+var quantitySuffixes = map[string]*big.Rat{
+	"":   big.NewRat(1, 1),
+	"m":  big.NewRat(1, 1000),
+	"k":  new(big.Rat).SetFloat64(1e3),
+	"M":  new(big.Rat).SetFloat64(1e6),
+	"G":  new(big.Rat).SetFloat64(1e9),
+	"T":  new(big.Rat).SetFloat64(1e12),
+	"P":  new(big.Rat).SetFloat64(1e15),
+	"E":  new(big.Rat).SetFloat64(1e18),
+	"Ki": new(big.Rat).SetFloat64(1 << 10),
+	"Mi": new(big.Rat).SetFloat64(1 << 20),
+	"Gi": new(big.Rat).SetFloat64(1 << 30),
+	"Ti": new(big.Rat).SetFloat64(1 << 40),
+	"Pi": new(big.Rat).SetFloat64(1 << 50),
+	"Ei": new(big.Rat).SetFloat64(1 << 60),
+}
+
+/*
+ * Parses numbers and kubernetes quantities like 500m, 1.5Gi or 1e3.
+ */
+func parseQuantity(value string) (*big.Rat, bool) {
+	match := quantityPattern.FindStringSubmatch(value)
+
+	if match == nil {
+		return nil, false
+	}
+
+	number, ok := new(big.Rat).SetString(match[1])
+
+	if !ok {
+		return nil, false
+	}
+
+	return number.Mul(number, quantitySuffixes[match[2]]), true
+}
+
+/*
+ * The api server stores stringData of Secrets base64 encoded in data.
+ */
+func encodeStringData(object map[string]interface{}) {
+	stringData, ok := object["stringData"].(map[string]interface{})
+
+	if !ok {
+		return
+	}
+
+	data, ok := object["data"].(map[string]interface{})
+
+	if !ok {
+		data = make(map[string]interface{})
+	}
+
+	for key, value := range stringData {
+		data[key] = base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(value)))
+	}
+
+	object["data"] = data
+	delete(object, "stringData")
+}
+
+func marshalForDiff(object interface{}) (string, error) {
+	text, err := yaml.Marshal(object)
+
+	return string(text), err
+}
+
+/*
+ * Removes the annotations which change on every render, so they are not
+ * reported as changes.
+ */
+func stripVolatileAnnotations(object map[string]interface{}) {
+	metadata, _ := object["metadata"].(map[string]interface{})
+	annotations, ok := metadata["annotations"].(map[string]interface{})
+
+	if !ok {
+		return
+	}
+
+	for _, annotation := range VOLATILE_ANNOTATIONS {
+		delete(annotations, annotation)
+	}
+
+	if len(annotations) == 0 {
+		delete(metadata, "annotations")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{"app.yml": testAppTemplate})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+	kubeClient := NewFakeKubeClient()
+
+	output, changed, err := diff(kubeClient, testDeployerSpec(projectDir, "master"))
+
+	assert.Nil(err)
+	assert.True(changed)
+	assert.Contains(output, "--- live/Service/master-web\n+++ rendered/Service/master-web\n")
+	assert.Contains(output, "+kind: Service\n")
+
+	err = deploy(kubeClient, testDeployerSpec(projectDir, "master"), DeployOptions{})
+	assert.Nil(err)
+
+	// Fields populated by the api server
+	deployment := kubeClient.Object("staging", "Deployment", "master-web")
+	deployment["status"] = map[interface{}]interface{}{"replicas": 1}
+	deployment["metadata"].(map[interface{}]interface{})["resourceVersion"] = "123"
+	deployment["metadata"].(map[interface{}]interface{})["managedFields"] = []interface{}{}
+	deployment["spec"].(map[interface{}]interface{})["replicas"] = 1
+	deployment["spec"].(map[interface{}]interface{})["strategy"] = map[interface{}]interface{}{"type": "RollingUpdate"}
+	container := NestedSlice(deployment, "spec", "template", "spec", "containers")[0].(map[interface{}]interface{})
+	container["imagePullPolicy"] = "IfNotPresent"
+
+	output, changed, err = diff(kubeClient, testDeployerSpec(projectDir, "master"))
+
+	assert.Nil(err)
+	assert.False(changed)
+	assert.Equal("", output)
+
+	deployerSpec := testDeployerSpec(projectDir, "master")
+	deployerSpec.TagVersion = "43"
+
+	output, changed, err = diff(kubeClient, deployerSpec)
+
+	assert.Nil(err)
+	assert.True(changed)
+	assert.Contains(output, "-      - image: foo/bar:42\n+      - image: foo/bar:43\n")
+	assert.Contains(output, "-    version: \"42\"\n+    version: \"43\"\n")
+}
+
+func TestDiffIgnoresExpiresAt(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{"app.yml": testAppTemplate})
+	defer os.RemoveAll(projectDir)
+
+	defer func() { now = time.Now }()
+
+	assert := assert.New(t)
+	kubeClient := NewFakeKubeClient()
+
+	deployerSpec := testDeployerSpec(projectDir, "feature-1")
+	deployerSpec.Ttl = 24 * time.Hour
+
+	now = func() time.Time { return time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC) }
+	err := deploy(kubeClient, deployerSpec, DeployOptions{})
+	assert.Nil(err)
+
+	now = func() time.Time { return time.Date(2018, 3, 2, 12, 0, 0, 0, time.UTC) }
+	output, changed, err := diff(kubeClient, deployerSpec)
+
+	assert.Nil(err)
+	assert.False(changed)
+	assert.Equal("", output)
+}
+
+func TestPruneToTemplateNumbers(t *testing.T) {
+	assert := assert.New(t)
+
+	live := map[string]interface{}{
+		"replicas": float64(1000000),
+		"ratio":    0.25,
+		"port":     "80",
+		"value":    float64(1),
+		"resources": map[string]interface{}{
+			"cpu":     "500m",
+			"memory":  "1Gi",
+			"storage": "2Gi",
+		},
+	}
+
+	template := map[string]interface{}{
+		"replicas": 1000000,
+		"ratio":    0.25,
+		"port":     80,
+		"value":    "1",
+		"resources": map[string]interface{}{
+			"cpu":     0.5,
+			"memory":  "1024Mi",
+			"storage": "1Gi",
+		},
+	}
+
+	pruned := PruneToTemplate(live, template)
+
+	text, err := marshalForDiff(pruned)
+	assert.Nil(err)
+	assert.Contains(text, "replicas: 1000000\n")
+
+	// outside of quantities a changed type is a change
+	assert.Equal(map[string]interface{}{
+		"replicas": 1000000,
+		"ratio":    0.25,
+		"port":     "80",
+		"value":    1,
+		"resources": map[string]interface{}{
+			"cpu":     0.5,
+			"memory":  "1024Mi",
+			"storage": "2Gi",
+		},
+	}, pruned)
+}
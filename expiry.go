@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const EXPIRES_AT_ANNOTATION = "kube-deployer/expires-at"
+
+// Replaced in tests
+var now = time.Now
+
+/*
+ * Parses a ttl like "36h", "90m" or "14d". An empty ttl means no expiry.
+ */
+func ParseTtl(ttl string) (time.Duration, error) {
+	if ttl == "" {
+		return 0, nil
+	}
+
+	if strings.HasSuffix(ttl, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(ttl, "d"))
+
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid ttl %s", ttl)
+		}
+
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	duration, err := time.ParseDuration(ttl)
+
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid ttl %s", ttl)
+	}
+
+	return duration, nil
+}
+
+/*
+ * Returns the envs whose latest expires-at annotation lies in the past.
+ * Objects without the annotation do not count.
+ */
+func ExpiredEnvs(objects []map[string]interface{}, at time.Time) []string {
+	expiresAt := make(map[string]time.Time)
+
+	for _, object := range objects {
+		env := NestedString(object, "metadata", "labels", "env")
+		objectExpiresAt, err := time.Parse(time.RFC3339, NestedString(object, "metadata", "annotations", EXPIRES_AT_ANNOTATION))
+
+		if env == "" || err != nil {
+			continue
+		}
+
+		if objectExpiresAt.After(expiresAt[env]) {
+			expiresAt[env] = objectExpiresAt
+		}
+	}
+
+	envs := make([]string, 0)
+
+	for env, envExpiresAt := range expiresAt {
+		if envExpiresAt.Before(at) {
+			envs = append(envs, env)
+		}
+	}
+
+	sort.Strings(envs)
+
+	return envs
+}
+
+/*
+ * Deletes all objects of envs whose ttl has passed.
+ */
+func cleanExpired(kubeClient KubeClient, namespace string, labelList map[string]string, protect ProtectRules, dryRun bool) error {
+	selector := "env"
+	if len(labelList) > 0 {
+		selector += "," + LabelSelector(labelList)
+	}
+
+	objects, err := kubeClient.ListObjects(namespace, selector)
+
+	if err != nil {
+		return err
+	}
+
+	for _, env := range ExpiredEnvs(objects, now()) {
+		fmt.Printf("Env %s expired\n", env)
+
+		envObjects := make([]map[string]interface{}, 0)
+
+		for _, object := range objects {
+			if NestedString(object, "metadata", "labels", "env") == env {
+				envObjects = append(envObjects, object)
+			}
+		}
+
+		err = deleteUnprotected(kubeClient, namespace, envObjects, protect, dryRun)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
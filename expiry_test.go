@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseTtl(t *testing.T) {
+	testSet := map[string]time.Duration{
+		"":    0,
+		"90m": 90 * time.Minute,
+		"36h": 36 * time.Hour,
+		"14d": 14 * 24 * time.Hour,
+	}
+
+	for ttl, expected := range testSet {
+		actual, err := ParseTtl(ttl)
+
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+	}
+
+	for _, ttl := range []string{"d", "1w", "-3h", "two days"} {
+		_, err := ParseTtl(ttl)
+
+		assert.EqualError(t, err, "invalid ttl "+ttl)
+	}
+}
+
+func TestCleanExpired(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{"app.yml": testAppTemplate})
+	defer os.RemoveAll(projectDir)
+
+	defer func() { now = time.Now }()
+
+	assert := assert.New(t)
+	kubeClient := NewFakeKubeClient()
+	deployedAt := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	deploySpecAt := func(branch string, ttl time.Duration, at time.Time) {
+		now = func() time.Time { return at }
+
+		deployerSpec := testDeployerSpec(projectDir, branch)
+		deployerSpec.Ttl = ttl
+
+		err := deploy(kubeClient, deployerSpec, DeployOptions{})
+		assert.Nil(err)
+	}
+
+	deploySpecAt("master", 0, deployedAt)
+	deploySpecAt("feature-1", 24*time.Hour, deployedAt)
+	deploySpecAt("feature-2", 24*time.Hour, deployedAt)
+	deploySpecAt("feature-2", 24*time.Hour, deployedAt.Add(12*time.Hour))
+
+	assert.Equal("2018-03-03T00:00:00Z", NestedString(kubeClient.Object("staging", "Service", "feature-2-web"), "metadata", "annotations", EXPIRES_AT_ANNOTATION))
+	assert.Empty(NestedString(kubeClient.Object("staging", "Service", "master-web"), "metadata", "annotations", EXPIRES_AT_ANNOTATION))
+
+	projectBranchHashes := map[string]string{
+		MD5("master"):    "master",
+		MD5("feature-1"): "feature-1",
+		MD5("feature-2"): "feature-2",
+	}
+
+	now = func() time.Time { return deployedAt.Add(25 * time.Hour) }
+
+	err := clean(kubeClient, "staging", projectBranchHashes, map[string]string{}, CleanOptions{})
+
+	assert.Nil(err)
+	assert.NotNil(kubeClient.Object("staging", "Deployment", "feature-1-web"))
+
+	err = clean(kubeClient, "staging", projectBranchHashes, map[string]string{}, CleanOptions{Expired: true})
+
+	assert.Nil(err)
+	assert.Nil(kubeClient.Object("staging", "Deployment", "feature-1-web"))
+	assert.Nil(kubeClient.Object("staging", "Service", "feature-1-web"))
+	assert.Nil(kubeClient.Object("staging", "Secret", "feature-1-release-42"))
+	assert.NotNil(kubeClient.Object("staging", "Deployment", "feature-2-web"))
+	assert.NotNil(kubeClient.Object("staging", "Deployment", "master-web"))
+}
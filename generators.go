@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const GENERATOR_HASH_LENGTH = 10
+
+var generatorKey = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+/*
+ * Builds a ConfigMap or Secret from files. The object is named
+ * <name>-<content hash>, so a change creates a new object and rolls the
+ * workloads referencing it. Templates reference it by its plain name.
+ */
+type Generator struct {
+	Kind  string   `yaml:"kind"`
+	Name  string   `yaml:"name"`
+	Dir   string   `yaml:"dir"`
+	Files []string `yaml:"files"`
+	Envs  []string `yaml:"envs"`
+}
+
+/*
+ * Returns the generated objects and their name suffixes by kind and name.
+ */
+func (deployerSpec DeployerSpec) GenerateObjects() ([]map[string]interface{}, map[string]map[string]string, error) {
+	objects := make([]map[string]interface{}, 0)
+	suffixes := map[string]map[string]string{}
+
+	for _, generator := range deployerSpec.Generators {
+		object, suffix, err := generator.Generate(deployerSpec.ProjectDir)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("generator %s: %v", generator.Name, err)
+		}
+
+		if suffixes[generator.Kind] == nil {
+			suffixes[generator.Kind] = map[string]string{}
+		}
+
+		suffixes[generator.Kind][generator.Name] = suffix
+		objects = append(objects, object)
+	}
+
+	return objects, suffixes, nil
+}
+
+/*
+ * Name suffixes and env aware names are looked up by kind and name, so
+ * templates, generators and secrets must not define the same object twice.
+ */
+func checkDuplicateObjects(objects []map[string]interface{}) error {
+	keys := map[string]bool{}
+
+	for _, object := range objects {
+		key := objectKey(object)
+
+		if keys[key] {
+			return fmt.Errorf("%s is defined more than once in the templates, generators and secrets", key)
+		}
+
+		keys[key] = true
+	}
+
+	return nil
+}
+
+func objectKey(object map[string]interface{}) string {
+	return NestedString(object, "kind") + "/" + NestedString(object, "metadata", "name")
+}
+
+func (generator Generator) Generate(projectDir string) (map[string]interface{}, string, error) {
+	if generator.Kind != K8S_CONFIGMAP && generator.Kind != K8S_SECRET {
+		return nil, "", fmt.Errorf("kind must be %s or %s", K8S_CONFIGMAP, K8S_SECRET)
+	}
+
+	if generator.Name == "" {
+		return nil, "", fmt.Errorf("name is required")
+	}
+
+	entries, err := generator.entries(projectDir)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	data := map[interface{}]interface{}{}
+	binaryData := map[interface{}]interface{}{}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var hashInput bytes.Buffer
+
+	for _, key := range keys {
+		value := entries[key]
+		fmt.Fprintf(&hashInput, "%s=%d:%s\n", key, len(value), value)
+
+		switch {
+		case generator.Kind == K8S_SECRET:
+			data[key] = base64.StdEncoding.EncodeToString(value)
+		case utf8.Valid(value):
+			data[key] = string(value)
+		default:
+			binaryData[key] = base64.StdEncoding.EncodeToString(value)
+		}
+	}
+
+	suffix := sha256Hex(append([]byte(generator.Kind+"\n"), hashInput.Bytes()...))[:GENERATOR_HASH_LENGTH]
+
+	object := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       generator.Kind,
+		"metadata": map[interface{}]interface{}{
+			"name": generator.Name,
+		},
+		"data": data,
+	}
+
+	if generator.Kind == K8S_SECRET {
+		object["type"] = "Opaque"
+	}
+
+	if len(binaryData) > 0 {
+		object["binaryData"] = binaryData
+	}
+
+	return object, suffix, nil
+}
+
+func (generator Generator) entries(projectDir string) (map[string][]byte, error) {
+	entries := map[string][]byte{}
+
+	add := func(key string, value []byte) error {
+		if !generatorKey.MatchString(key) {
+			return fmt.Errorf("invalid key %s", key)
+		}
+
+		if _, ok := entries[key]; ok {
+			return fmt.Errorf("duplicate key %s", key)
+		}
+
+		entries[key] = value
+
+		return nil
+	}
+
+	if generator.Dir != "" {
+		files, err := ioutil.ReadDir(filepath.Join(projectDir, generator.Dir))
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot read dir %s", generator.Dir)
+		}
+
+		for _, file := range files {
+			if !file.Mode().IsRegular() {
+				continue
+			}
+
+			content, err := ioutil.ReadFile(filepath.Join(projectDir, generator.Dir, file.Name()))
+
+			if err == nil {
+				err = add(file.Name(), content)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Files are "path" or "key=path"
+	for _, file := range generator.Files {
+		key, filePath := filepath.Base(file), file
+
+		if parts := strings.SplitN(file, "=", 2); len(parts) == 2 {
+			key, filePath = parts[0], parts[1]
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(projectDir, filePath))
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot read file %s", filePath)
+		}
+
+		if err = add(key, content); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, envFile := range generator.Envs {
+		content, err := ioutil.ReadFile(filepath.Join(projectDir, envFile))
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot read file %s", envFile)
+		}
+
+		env, err := parseEnvFile(content)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", envFile, err)
+		}
+
+		for key, value := range env {
+			if err = add(key, []byte(value)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+/*
+ * Parses KEY=value lines, empty lines and lines starting with # are skipped.
+ * Values are taken literally, quotes are not removed.
+ */
+func parseEnvFile(content []byte) (map[string]string, error) {
+	env := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=value", lineNumber)
+		}
+
+		env[strings.TrimSpace(parts[0])] = parts[1]
+	}
+
+	return env, scanner.Err()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testGeneratorTemplate = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+        - name: nginx
+          image: "{{ context.containers.php.name }}"
+          envFrom:
+            - configMapRef:
+                name: app-env
+            - secretRef:
+                name: app-secrets
+      volumes:
+        - name: nginx
+          configMap:
+            name: nginx
+`
+
+func TestGenerators(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		"app.yml":                 testGeneratorTemplate,
+		"config/nginx/nginx.conf": "server {\n    listen 80;\n    set $x \"{{ y }}\";\n}\n",
+		"config/nginx/mime.types": "types {}\n",
+		"config/app.env":          "# comment\nAPP_ENV=staging\n\nAPP_NAME=\"web\"\n",
+		"config/secret.txt":       "s3cret",
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+
+	deployerSpec := testDeployerSpec(projectDir, "master")
+	deployerSpec.Generators = []Generator{
+		{Kind: K8S_CONFIGMAP, Name: "nginx", Dir: "config/nginx"},
+		{Kind: K8S_CONFIGMAP, Name: "app-env", Envs: []string{"config/app.env"}},
+		{Kind: K8S_SECRET, Name: "app-secrets", Files: []string{"password=config/secret.txt"}},
+	}
+
+	_, suffixes, err := deployerSpec.GenerateObjects()
+	assert.Nil(err)
+	assert.Len(suffixes[K8S_CONFIGMAP]["nginx"], GENERATOR_HASH_LENGTH)
+
+	nginxName := "master-nginx-" + suffixes[K8S_CONFIGMAP]["nginx"]
+	appEnvName := "master-app-env-" + suffixes[K8S_CONFIGMAP]["app-env"]
+	secretName := "master-app-secrets-" + suffixes[K8S_SECRET]["app-secrets"]
+
+	definition, err := render(deployerSpec)
+	assert.Nil(err)
+
+	objects, err := UnmarshalYaml(definition)
+	assert.Nil(err)
+	assert.Len(objects, 4)
+
+	podSpec := NestedValue(objects[0], "spec", "template", "spec")
+	container := NestedSlice(podSpec, "containers")[0]
+	assert.Equal(appEnvName, NestedString(NestedSlice(container, "envFrom")[0], "configMapRef", "name"))
+	assert.Equal(secretName, NestedString(NestedSlice(container, "envFrom")[1], "secretRef", "name"))
+	assert.Equal(nginxName, NestedString(NestedSlice(podSpec, "volumes")[0], "configMap", "name"))
+
+	assert.Equal(nginxName, NestedString(objects[1], "metadata", "name"))
+	assert.Equal("master", NestedString(objects[1], "metadata", "labels", "env"))
+	assert.Equal("server {\n    listen 80;\n    set $x \"{{ y }}\";\n}\n", NestedString(objects[1], "data", "nginx.conf"))
+	assert.Equal("types {}\n", NestedString(objects[1], "data", "mime.types"))
+
+	assert.Equal("staging", NestedString(objects[2], "data", "APP_ENV"))
+	assert.Equal("\"web\"", NestedString(objects[2], "data", "APP_NAME"))
+
+	assert.Equal(base64.StdEncoding.EncodeToString([]byte("s3cret")), NestedString(objects[3], "data", "password"))
+
+	// a content change changes the name
+	os.Remove(projectDir + "/config/nginx/mime.types")
+
+	_, changedSuffixes, err := deployerSpec.GenerateObjects()
+	assert.Nil(err)
+	assert.NotEqual(suffixes[K8S_CONFIGMAP]["nginx"], changedSuffixes[K8S_CONFIGMAP]["nginx"])
+	assert.Equal(suffixes[K8S_CONFIGMAP]["app-env"], changedSuffixes[K8S_CONFIGMAP]["app-env"])
+}
+
+func TestGeneratorErrors(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		"a/config.yml": "a: b",
+		"b/config.yml": "c: d",
+		"broken.env":   "APP_ENV\n",
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+
+	_, _, err := Generator{Kind: "Pod", Name: "x"}.Generate(projectDir)
+	assert.EqualError(err, "kind must be ConfigMap or Secret")
+
+	_, _, err = Generator{Kind: K8S_CONFIGMAP, Name: "x", Files: []string{"a/config.yml", "b/config.yml"}}.Generate(projectDir)
+	assert.EqualError(err, "duplicate key config.yml")
+
+	_, _, err = Generator{Kind: K8S_CONFIGMAP, Name: "x", Envs: []string{"broken.env"}}.Generate(projectDir)
+	assert.EqualError(err, "broken.env: line 1: expected KEY=value")
+
+	deployerSpec := DeployerSpec{ProjectDir: projectDir, Generators: []Generator{{Kind: K8S_CONFIGMAP, Name: "x", Dir: "missing"}}}
+	_, _, err = deployerSpec.GenerateObjects()
+	assert.True(strings.HasPrefix(err.Error(), "generator x: cannot read dir missing"))
+}
+
+func TestGeneratorDuplicates(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		"app.yml":           testGeneratorTemplate + "---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: nginx\ndata:\n  a: b\n",
+		"config/app.env":    "APP_ENV=staging\n",
+		"config/nginx.conf": "server {}\n",
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+
+	deployerSpec := testDeployerSpec(projectDir, "master")
+	deployerSpec.Generators = []Generator{
+		{Kind: K8S_CONFIGMAP, Name: "nginx", Files: []string{"config/nginx.conf"}},
+	}
+
+	_, err := render(deployerSpec)
+	assert.EqualError(err, "ConfigMap/nginx is defined more than once in the templates, generators and secrets")
+
+	deployerSpec.Generators = []Generator{
+		{Kind: K8S_CONFIGMAP, Name: "app-env", Envs: []string{"config/app.env"}},
+		{Kind: K8S_CONFIGMAP, Name: "app-env", Files: []string{"config/nginx.conf"}},
+	}
+
+	_, err = render(deployerSpec)
+	assert.EqualError(err, "ConfigMap/app-env is defined more than once in the templates, generators and secrets")
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aymerick/raymond"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Helpers available in all templates. Helpers writing text return a
+ * SafeString, their output must not be html escaped.
+ */
+func init() {
+	raymond.RegisterHelpers(map[string]interface{}{
+		"b64enc":  b64encHelper,
+		"sha256":  sha256Helper,
+		"default": defaultHelper,
+		"upper":   upperHelper,
+		"lower":   lowerHelper,
+		"quote":   quoteHelper,
+		"toJson":  toJsonHelper,
+		"indent":  indentHelper,
+		"replace": replaceHelper,
+		"eq":      eqHelper,
+		"ne":      neHelper,
+		"getenv":  getenvHelper,
+		"add":     arithmeticHelper("add", func(a, b int64) int64 { return a + b }),
+		"sub":     arithmeticHelper("sub", func(a, b int64) int64 { return a - b }),
+		"mul":     arithmeticHelper("mul", func(a, b int64) int64 { return a * b }),
+		"div":     arithmeticHelper("div", func(a, b int64) int64 { return a / b }),
+		"mod":     arithmeticHelper("mod", func(a, b int64) int64 { return a % b }),
+	})
+}
+
+const HELPER_ERRORS = "helperErrors"
+
+/*
+ * Helpers can only return their output, errors are collected in the data
+ * frame of the template and returned by Render.
+ */
+func helperError(options *raymond.Options, err error) {
+	if errors, ok := options.DataFrame().Get(HELPER_ERRORS).(*[]error); ok {
+		*errors = append(*errors, err)
+	}
+}
+
+// {{b64enc value}}
+func b64encHelper(value interface{}) raymond.SafeString {
+	return raymond.SafeString(base64.StdEncoding.EncodeToString([]byte(raymond.Str(value))))
+}
+
+// {{sha256 value}}, hex encoded
+func sha256Helper(value interface{}) raymond.SafeString {
+	hash := sha256.Sum256([]byte(raymond.Str(value)))
+
+	return raymond.SafeString(hex.EncodeToString(hash[:]))
+}
+
+// {{default value "fallback"}}, the fallback is used for empty values
+func defaultHelper(value interface{}, fallback interface{}) interface{} {
+	if raymond.IsTrue(value) {
+		return value
+	}
+
+	return fallback
+}
+
+// {{upper value}}
+func upperHelper(value interface{}) raymond.SafeString {
+	return raymond.SafeString(strings.ToUpper(raymond.Str(value)))
+}
+
+// {{lower value}}
+func lowerHelper(value interface{}) raymond.SafeString {
+	return raymond.SafeString(strings.ToLower(raymond.Str(value)))
+}
+
+// {{quote value}}, a double quoted string which is also valid yaml
+func quoteHelper(value interface{}) raymond.SafeString {
+	return raymond.SafeString(strconv.Quote(raymond.Str(value)))
+}
+
+// {{toJson value}}
+func toJsonHelper(value interface{}, options *raymond.Options) raymond.SafeString {
+	output, err := json.Marshal(normalizeValue(value))
+
+	if err != nil {
+		helperError(options, fmt.Errorf("toJson: %v", err))
+		return ""
+	}
+
+	return raymond.SafeString(output)
+}
+
+// {{indent 4 value}}, indents every line
+func indentHelper(width int, value interface{}) raymond.SafeString {
+	prefix := strings.Repeat(" ", width)
+
+	return raymond.SafeString(prefix + strings.Replace(raymond.Str(value), "\n", "\n"+prefix, -1))
+}
+
+// {{replace "old" "new" value}}
+func replaceHelper(old string, new string, value interface{}) raymond.SafeString {
+	return raymond.SafeString(strings.Replace(raymond.Str(value), old, new, -1))
+}
+
+// {{#if (eq a b)}}, values are compared by their string representation
+func eqHelper(a interface{}, b interface{}) bool {
+	return raymond.Str(a) == raymond.Str(b)
+}
+
+// {{#if (ne a b)}}
+func neHelper(a interface{}, b interface{}) bool {
+	return !eqHelper(a, b)
+}
+
+// {{getenv "NAME"}}
+func getenvHelper(name string) raymond.SafeString {
+	return raymond.SafeString(os.Getenv(name))
+}
+
+// {{add a b}}, {{sub a b}}, {{mul a b}}, {{div a b}} and {{mod a b}} on integers
+func arithmeticHelper(name string, operation func(int64, int64) int64) func(interface{}, interface{}, *raymond.Options) interface{} {
+	return func(a interface{}, b interface{}, options *raymond.Options) interface{} {
+		x, errA := strconv.ParseInt(raymond.Str(a), 10, 64)
+		y, errB := strconv.ParseInt(raymond.Str(b), 10, 64)
+
+		if errA != nil || errB != nil {
+			helperError(options, fmt.Errorf("%s: %q and %q must be integers", name, raymond.Str(a), raymond.Str(b)))
+			return ""
+		}
+
+		if y == 0 && (name == "div" || name == "mod") {
+			helperError(options, fmt.Errorf("%s: division by zero", name))
+			return ""
+		}
+
+		return operation(x, y)
+	}
+}
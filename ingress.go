@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var ingressHostPlaceholder = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+/*
+ * Renders the ingress host pattern of the target, e.g.
+ * {{env}}.staging.example.com. Returns an empty string if the target has no
+ * pattern or the env is a production env which keeps the hosts of the
+ * templates.
+ */
+func (deployerSpec DeployerSpec) IngressHost(renderContext RenderContext) (string, error) {
+	if deployerSpec.IngressHostPattern == "" {
+		return "", nil
+	}
+
+	for _, productionEnv := range deployerSpec.ProductionEnvs {
+		if matched, _ := path.Match(productionEnv, deployerSpec.Env); matched {
+			return "", nil
+		}
+	}
+
+	/*
+	 * Placeholders are replaced directly instead of rendering the pattern
+	 * with handlebars, only these values are available.
+	 */
+	values := map[string]string{
+		"env":       renderContext.Env,
+		"branch":    renderContext.Branch,
+		"project":   renderContext.Project,
+		"namespace": renderContext.Namespace,
+	}
+
+	var err error
+
+	host := ingressHostPlaceholder.ReplaceAllStringFunc(deployerSpec.IngressHostPattern, func(placeholder string) string {
+		value, ok := values[ingressHostPlaceholder.FindStringSubmatch(placeholder)[1]]
+
+		if !ok {
+			err = fmt.Errorf("ingress host %s: unknown placeholder %s", deployerSpec.IngressHostPattern, placeholder)
+		}
+
+		return value
+	})
+
+	if err == nil && (strings.Contains(host, "{{") || strings.Contains(host, "}}")) {
+		err = fmt.Errorf("ingress host %s: unclosed placeholder", deployerSpec.IngressHostPattern)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return host, nil
+}
+
+func injectMetaDataIntoIngress(envAwareObjectName string, injectContext InjectContext, object map[string]interface{}) map[string]interface{} {
+	object = inject(envAwareObjectName, injectContext, object)
+
+	if injectContext.IngressHost == "" {
+		return object
+	}
+
+	hosts := map[string]string{"": injectContext.IngressHost}
+
+	/*
+	 * An Ingress for several hosts gets one env host per host, its first
+	 * label is prefixed to the env host, e.g. api-feature-1.example.com.
+	 */
+	if originalHosts := ingressHosts(object); len(originalHosts) > 1 {
+		for _, host := range originalHosts {
+			hosts[host] = envIngressHost(host, injectContext.IngressHost, hosts)
+		}
+	} else {
+		for _, host := range originalHosts {
+			hosts[host] = injectContext.IngressHost
+		}
+	}
+
+	for _, rule := range NestedSlice(object, "spec", "rules") {
+		if rule, ok := rule.(map[interface{}]interface{}); ok {
+			rule["host"] = hosts[NestedString(rule, "host")]
+		}
+	}
+
+	for _, tls := range NestedSlice(object, "spec", "tls") {
+		if tls, ok := tls.(map[interface{}]interface{}); ok {
+			tlsHosts := make([]string, 0)
+
+			for _, host := range NestedSlice(tls, "hosts") {
+				tlsHosts = append(tlsHosts, hosts[fmt.Sprint(host)])
+			}
+
+			if len(tlsHosts) == 0 {
+				tlsHosts = append(tlsHosts, injectContext.IngressHost)
+			}
+
+			tlsHostValues := make([]interface{}, 0)
+			for _, host := range Unique(tlsHosts) {
+				tlsHostValues = append(tlsHostValues, host)
+			}
+
+			tls["hosts"] = tlsHostValues
+		}
+	}
+
+	return object
+}
+
+/*
+ * Prefixes the first label of the host to the env host. Hosts sharing their
+ * first label with an already mapped host use their whole host instead.
+ */
+func envIngressHost(host string, envHost string, mappedHosts map[string]string) string {
+	derived := deriveIngressHost(strings.SplitN(host, ".", 2)[0], envHost)
+
+	for _, mappedHost := range mappedHosts {
+		if mappedHost == derived {
+			return deriveIngressHost(strings.Replace(host, ".", "-", -1), envHost)
+		}
+	}
+
+	return derived
+}
+
+func deriveIngressHost(prefix string, envHost string) string {
+	labels := strings.SplitN(envHost, ".", 2)
+	labels[0] = MakeUrlSlug(prefix+"-"+labels[0], DNS_MAX_LENGTH)
+
+	return strings.Join(labels, ".")
+}
+
+/*
+ * Returns the distinct hosts of the rules and tls entries of an Ingress.
+ */
+func ingressHosts(object map[string]interface{}) []string {
+	hosts := make([]string, 0)
+
+	for _, rule := range NestedSlice(object, "spec", "rules") {
+		hosts = append(hosts, NestedString(rule, "host"))
+	}
+
+	for _, tls := range NestedSlice(object, "spec", "tls") {
+		for _, host := range NestedSlice(tls, "hosts") {
+			if host, ok := host.(string); ok {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+
+	return Filter(Unique(hosts), func(host string) bool {
+		return host != ""
+	})
+}
+
+/*
+ * Returns the urls of all hosts of the Ingresses in the definition, https for
+ * hosts listed in a tls entry.
+ */
+func IngressUrls(definition string) ([]string, error) {
+	objects, err := UnmarshalYaml(definition)
+
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]string, 0)
+
+	for _, object := range objects {
+		if object["kind"] != K8S_INGRESS {
+			continue
+		}
+
+		tlsHosts := make([]string, 0)
+
+		for _, tls := range NestedSlice(object, "spec", "tls") {
+			for _, host := range NestedSlice(tls, "hosts") {
+				tlsHosts = append(tlsHosts, fmt.Sprint(host))
+			}
+		}
+
+		for _, rule := range NestedSlice(object, "spec", "rules") {
+			host := NestedString(rule, "host")
+
+			if host == "" {
+				continue
+			}
+
+			scheme := "http"
+			if Contains(tlsHosts, host) {
+				scheme = "https"
+			}
+
+			urls = append(urls, scheme+"://"+host)
+		}
+	}
+
+	urls = Unique(urls)
+	sort.Strings(urls)
+
+	return urls, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testIngressTemplate = `
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  tls:
+    - hosts:
+        - www.example.com
+      secretName: tls
+  rules:
+    - host: www.example.com
+      http:
+        paths:
+          - path: /
+            pathType: Prefix
+            backend:
+              service:
+                name: web
+                port:
+                  number: 80
+    - host: api.example.com
+      http:
+        paths:
+          - path: /
+            pathType: Prefix
+            backend:
+              service:
+                name: web
+                port:
+                  number: 80
+`
+
+func TestIngressHost(t *testing.T) {
+	template := strings.Replace(testIngressTemplate, "api.example.com", "www.example.com", 1)
+	projectDir := writeTestProject(t, map[string]string{"app.yml": template})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+
+	deployerSpec := testDeployerSpec(projectDir, "feature/login")
+	deployerSpec.IngressHostPattern = "{{env}}.staging.example.com"
+	deployerSpec.ProductionEnvs = []string{"production", "static-*"}
+
+	definition, err := render(deployerSpec)
+	assert.Nil(err)
+
+	objects, err := UnmarshalYaml(definition)
+	assert.Nil(err)
+
+	rules := NestedSlice(objects[0], "spec", "rules")
+	assert.Equal("featurelogin.staging.example.com", NestedString(rules[0], "host"))
+	assert.Equal("featurelogin.staging.example.com", NestedString(rules[1], "host"))
+	assert.Equal([]interface{}{"featurelogin.staging.example.com"}, NestedSlice(NestedSlice(objects[0], "spec", "tls")[0], "hosts"))
+
+	urls, err := IngressUrls(definition)
+	assert.Nil(err)
+	assert.Equal([]string{"https://featurelogin.staging.example.com"}, urls)
+
+	deployerSpec.Env = "static-1"
+
+	definition, err = render(deployerSpec)
+	assert.Nil(err)
+
+	urls, err = IngressUrls(definition)
+	assert.Nil(err)
+	assert.Equal([]string{"https://www.example.com"}, urls)
+}
+
+func TestIngressHostSeveralHosts(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{"app.yml": testIngressTemplate})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+
+	deployerSpec := testDeployerSpec(projectDir, "feature/login")
+	deployerSpec.IngressHostPattern = "{{env}}.staging.example.com"
+	deployerSpec.ProductionEnvs = []string{"production", "static-*"}
+
+	definition, err := render(deployerSpec)
+	assert.Nil(err)
+
+	objects, err := UnmarshalYaml(definition)
+	assert.Nil(err)
+
+	rules := NestedSlice(objects[0], "spec", "rules")
+	assert.Equal("www-featurelogin.staging.example.com", NestedString(rules[0], "host"))
+	assert.Equal("api-featurelogin.staging.example.com", NestedString(rules[1], "host"))
+	assert.Equal([]interface{}{"www-featurelogin.staging.example.com"}, NestedSlice(NestedSlice(objects[0], "spec", "tls")[0], "hosts"))
+
+	urls, err := IngressUrls(definition)
+	assert.Nil(err)
+	assert.Equal([]string{"http://api-featurelogin.staging.example.com", "https://www-featurelogin.staging.example.com"}, urls)
+	assert.NotContains(definition, "www.example.com")
+	assert.NotContains(definition, "api.example.com")
+
+	deployerSpec.Env = "static-1"
+
+	definition, err = render(deployerSpec)
+	assert.Nil(err)
+
+	urls, err = IngressUrls(definition)
+	assert.Nil(err)
+	assert.Equal([]string{"http://api.example.com", "https://www.example.com"}, urls)
+}
+
+func TestEnvIngressHost(t *testing.T) {
+	mappedHosts := map[string]string{"www.example.com": "www-feature-1.example.com"}
+
+	assert.Equal(t, "api-feature-1.example.com", envIngressHost("api.example.com", "feature-1.example.com", mappedHosts))
+	assert.Equal(t, "www-example-org-feature-1.example.com", envIngressHost("www.example.org", "feature-1.example.com", mappedHosts))
+	assert.Equal(t, "api-feature-1", envIngressHost("api.example.com", "feature-1", mappedHosts))
+}
+
+func TestIngressHostInvalidPattern(t *testing.T) {
+	deployerSpec := DeployerSpec{Env: "feature-1", IngressHostPattern: "{{env.staging.example.com"}
+
+	_, err := deployerSpec.IngressHost(RenderContext{Env: "feature-1"})
+
+	assert.NotNil(t, err)
+}
+
+func TestIngressHostUnknownPlaceholder(t *testing.T) {
+	deployerSpec := DeployerSpec{Env: "feature-1", IngressHostPattern: "{{ env }}.{{ cluster }}.example.com"}
+
+	_, err := deployerSpec.IngressHost(RenderContext{Env: "feature-1"})
+
+	assert.EqualError(t, err, "ingress host {{ env }}.{{ cluster }}.example.com: unknown placeholder {{ cluster }}")
+}
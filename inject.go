@@ -3,16 +3,22 @@ package main
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"time"
 )
 
 const DEFAULT_REVISION_HISTORY_LIMIT = 3
 
 type InjectContext struct {
-	Objects    map[string]map[string]RenderContextEnvAwareObject
-	Env        string
-	Branch     string
-	Namespace  string
-	TagVersion string
+	Objects     map[string]map[string]RenderContextEnvAwareObject
+	Env         string
+	Branch      string
+	BranchName  string
+	Namespace   string
+	Project     string
+	TagVersion  string
+	ExpiresAt   time.Time
+	IngressHost string
+	Provenance  Provenance
 }
 
 func InjectMetadata(injectContext InjectContext, objects []map[string]interface{}) []map[string]interface{} {
@@ -28,13 +34,21 @@ func InjectMetadata(injectContext InjectContext, objects []map[string]interface{
 		case K8S_SERVICE:
 			injectMetaDataIntoService(envAwareObjectName, injectContext, object)
 			break
-		case K8S_DEPLOYMENT:
+		case K8S_DEPLOYMENT, K8S_STATEFULSET, K8S_DAEMONSET, K8S_REPLICASET:
 			injectMetaDataIntoDeployment(envAwareObjectName, injectContext, object)
 			break
+		case K8S_INGRESS:
+			injectMetaDataIntoIngress(envAwareObjectName, injectContext, object)
+			break
+		case K8S_JOB, K8S_CRONJOB:
+			injectMetaDataIntoJob(envAwareObjectName, injectContext, object)
+			break
 		default:
 			injectMetaDataIntoObject(envAwareObjectName, injectContext, object)
 			break
 		}
+
+		injectReferences(injectContext, object)
 	}
 
 	return objects
@@ -67,48 +81,90 @@ func injectMetaDataIntoDeployment(envAwareObjectName string, injectContext Injec
 	 * .spec.selector.matchLabels will be defaulted to .spec.template.metadata.labels.
 	 *
 	 * http://kubernetes.io/docs/user-guide/deployments/#selector
+	 *
+	 * The same applies to StatefulSets, DaemonSets and ReplicaSets.
 	 */
-	spec := object["spec"].(map[interface{}]interface{})
-	specTemplate := spec["template"].(map[interface{}]interface{})
+	spec := injectChildMap(object, "spec")
 
-	specTemplateMetadata := specTemplate["metadata"].(map[interface{}]interface{})
+	injectIntoPodTemplate(injectContext, spec, true)
+
+	/*
+	 * Limit replica set (controller revision) history if not set.
+	 */
+	if object["kind"] != K8S_REPLICASET && spec["revisionHistoryLimit"] == nil {
+		spec["revisionHistoryLimit"] = DEFAULT_REVISION_HISTORY_LIMIT
+	}
 
-	var specTemplateMetadataLabels map[interface{}]interface{}
+	return object
+}
 
-	if specTemplateMetadata["labels"].(map[interface{}]interface{}) == nil {
-		specTemplateMetadataLabels = map[interface{}]interface{}{
-			"env": injectContext.Env,
-		}
-	} else {
-		specTemplateMetadataLabels = specTemplateMetadata["labels"].(map[interface{}]interface{})
-		specTemplateMetadataLabels["env"] = injectContext.Env
+func injectMetaDataIntoJob(envAwareObjectName string, injectContext InjectContext, object map[string]interface{}) map[string]interface{} {
+	object = inject(envAwareObjectName, injectContext, object)
+
+	spec := injectChildMap(object, "spec")
+
+	if object["kind"] == K8S_CRONJOB {
+		spec = injectChildMap(injectChildMap(spec, "jobTemplate"), "spec")
 	}
 
+	/*
+	 * The selector of a job is generated from its uid unless
+	 * .spec.manualSelector is set, so it is never defaulted here.
+	 */
+	injectIntoPodTemplate(injectContext, spec, false)
+
+	return object
+}
+
+/*
+ * Adds the env label to .template.metadata.labels and .selector.matchLabels
+ * of the given spec.
+ */
+func injectIntoPodTemplate(injectContext InjectContext, spec map[interface{}]interface{}, defaultSelector bool) {
+	specTemplateMetadataLabels := injectChildMap(injectChildMap(injectChildMap(spec, "template"), "metadata"), "labels")
+	specTemplateMetadataLabels["env"] = injectContext.Env
+
 	if spec["selector"] == nil {
-		spec["selector"] = map[interface{}]interface{}{
-			"matchLabels": specTemplateMetadataLabels,
+		if !defaultSelector {
+			return
 		}
-	}
 
-	specSelector := spec["selector"].(map[interface{}]interface{})
+		matchLabels := map[interface{}]interface{}{}
+		for labelName, labelValue := range specTemplateMetadataLabels {
+			matchLabels[labelName] = labelValue
+		}
 
-	/*
-	 * Limit replica set history if not set.
-	 */
-	if spec["revisionHistoryLimit"] == nil {
-		spec["revisionHistoryLimit"] = DEFAULT_REVISION_HISTORY_LIMIT
+		spec["selector"] = map[interface{}]interface{}{
+			"matchLabels": matchLabels,
+		}
 	}
 
-	specSelectorMatchLabels := specSelector["matchLabels"].(map[interface{}]interface{})
+	specSelectorMatchLabels := injectChildMap(injectChildMap(spec, "selector"), "matchLabels")
 	specSelectorMatchLabels["env"] = injectContext.Env
+}
 
-	specSelector["matchLabels"] = specSelectorMatchLabels
-
-	spec["selector"] = specSelector
-
-	object["spec"] = spec
+/*
+ * Returns the map stored under the key, creating it if it is missing.
+ */
+func injectChildMap(parent interface{}, key string) map[interface{}]interface{} {
+	var child map[interface{}]interface{}
+
+	switch typed := parent.(type) {
+	case map[string]interface{}:
+		child, _ = typed[key].(map[interface{}]interface{})
+		if child == nil {
+			child = map[interface{}]interface{}{}
+			typed[key] = child
+		}
+	case map[interface{}]interface{}:
+		child, _ = typed[key].(map[interface{}]interface{})
+		if child == nil {
+			child = map[interface{}]interface{}{}
+			typed[key] = child
+		}
+	}
 
-	return object
+	return child
 }
 
 func injectMetaDataIntoObject(envAwareObjectName string, injectContext InjectContext, object map[string]interface{}) map[string]interface{} {
@@ -133,7 +189,37 @@ func inject(envAwareObjectName string, injectContext InjectContext, object map[s
 
 	labels["version"] = injectContext.TagVersion
 
+	if injectContext.Project != "" {
+		labels["project"] = injectContext.Project
+	}
+
 	metadata["labels"] = labels
+
+	if metadata["annotations"] == nil {
+		metadata["annotations"] = map[interface{}]interface{}{}
+	}
+
+	var annotations = metadata["annotations"].(map[interface{}]interface{})
+
+	for annotationName, annotationValue := range injectContext.Provenance.Annotations() {
+		annotations[annotationName] = annotationValue
+	}
+
+	// The branch label is slugged, protect rules need the real name
+	if injectContext.BranchName != "" {
+		annotations[BRANCH_ANNOTATION] = injectContext.BranchName
+	}
+
+	if !injectContext.ExpiresAt.IsZero() {
+		annotations[EXPIRES_AT_ANNOTATION] = injectContext.ExpiresAt.Format(time.RFC3339)
+	}
+
+	if len(annotations) == 0 {
+		delete(metadata, "annotations")
+	} else {
+		metadata["annotations"] = annotations
+	}
+
 	object["metadata"] = metadata
 
 	return object
@@ -131,3 +131,128 @@ spec:
 		assert.Equal(expectedName, actualName)
 	}
 }
+
+func TestInjectMetadataWorkloads(t *testing.T) {
+	var deployerSpec = DeployerSpec{
+		ProjectDir: ".",
+		TagVersion: "test-01",
+		Env:        "feature-1",
+		Branch:     "feature-1",
+		Namespace:  "default",
+	}
+
+	yaml := `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+spec:
+  serviceName: db
+  template:
+    metadata:
+      labels:
+        app: db
+
+---
+
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+spec:
+  revisionHistoryLimit: 5
+  selector:
+    matchLabels:
+      app: agent
+  template:
+    metadata:
+      labels:
+        app: agent
+
+---
+
+apiVersion: apps/v1
+kind: ReplicaSet
+metadata:
+  name: cache
+spec:
+  template:
+    metadata:
+      labels:
+        app: cache
+
+---
+
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  template:
+    spec:
+      restartPolicy: Never
+
+---
+
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: report
+spec:
+  schedule: "0 * * * *"
+  jobTemplate:
+    spec:
+      template:
+        metadata:
+          labels:
+            app: report
+`
+
+	objects, err := UnmarshalYaml(yaml)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var renderContext RenderContext
+	err = renderContext.Build(deployerSpec, objects)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects = InjectMetadata(InjectContext{
+		Objects:    renderContext.Objects,
+		Env:        renderContext.Env,
+		Branch:     renderContext.Branch,
+		Namespace:  renderContext.Namespace,
+		TagVersion: renderContext.DeployerSpec.TagVersion,
+	}, objects)
+
+	assert := assert.New(t)
+
+	statefulSet := objects[0]
+	assert.Equal("feature-1-db", NestedString(statefulSet, "metadata", "name"))
+	assert.Equal("feature-1", NestedString(statefulSet, "spec", "template", "metadata", "labels", "env"))
+	assert.Equal("db", NestedString(statefulSet, "spec", "selector", "matchLabels", "app"))
+	assert.Equal("feature-1", NestedString(statefulSet, "spec", "selector", "matchLabels", "env"))
+	assert.Equal(DEFAULT_REVISION_HISTORY_LIMIT, NestedValue(statefulSet, "spec", "revisionHistoryLimit"))
+
+	daemonSet := objects[1]
+	assert.Equal("feature-1", NestedString(daemonSet, "spec", "template", "metadata", "labels", "env"))
+	assert.Equal("feature-1", NestedString(daemonSet, "spec", "selector", "matchLabels", "env"))
+	assert.Equal(5, NestedValue(daemonSet, "spec", "revisionHistoryLimit"))
+
+	replicaSet := objects[2]
+	assert.Equal("feature-1", NestedString(replicaSet, "spec", "selector", "matchLabels", "env"))
+	assert.Nil(NestedValue(replicaSet, "spec", "revisionHistoryLimit"))
+
+	job := objects[3]
+	assert.Equal("feature-1", NestedString(job, "spec", "template", "metadata", "labels", "env"))
+	assert.Nil(NestedValue(job, "spec", "selector"))
+
+	cronJob := objects[4]
+	assert.Equal("feature-1", NestedString(cronJob, "spec", "jobTemplate", "spec", "template", "metadata", "labels", "env"))
+	assert.Equal("report", NestedString(cronJob, "spec", "jobTemplate", "spec", "template", "metadata", "labels", "app"))
+	assert.Nil(NestedValue(cronJob, "spec", "jobTemplate", "spec", "selector"))
+}
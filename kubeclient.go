@@ -0,0 +1,752 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const FIELD_MANAGER = "kube-deployer"
+const HTTP_TIMEOUT = 30 * time.Second
+
+/*
+ * Resources never looked at by clean, unless explicitly included. Their
+ * objects are maintained by the cluster itself.
+ */
+var DEFAULT_EXCLUDED_RESOURCES = []string{
+	"events",
+	"endpoints",
+	"endpointslices",
+}
+
+/*
+ * Restricts the resources clean and prune look at. Entries may be plural
+ * resource names (deployments), qualified names (deployments.apps) or kinds
+ * (Deployment). An empty include list means all namespaced resources.
+ */
+type ResourceFilter struct {
+	Include []string
+	Exclude []string
+}
+
+type KubeClient interface {
+	Version() (string, error)
+	Apply(definition string, namespace string, dryRun bool) (string, error)
+	Get(apiVersion string, kind string, namespace string, name string) (map[string]interface{}, error)
+	Delete(apiVersion string, kind string, namespace string, name string, dryRun bool) (string, error)
+	List(apiVersion string, kind string, namespace string, labelSelector string) ([]map[string]interface{}, error)
+	// Lists the objects of all resources clean looks at, skipping objects owned by other objects
+	ListObjects(namespace string, labelSelector string) ([]map[string]interface{}, error)
+	GetDeployedBranchHashes(namespace string, labelList map[string]string) ([]string, error)
+	DeleteObjectsByBranch(branchHash string, namespace string, labelList map[string]string, dryRun bool) (string, error)
+}
+
+// KubeApiClient talks to the Kubernetes API server directly over HTTPS.
+type KubeApiClient struct {
+	Server         string
+	Token          string
+	Context        string
+	ResourceFilter ResourceFilter
+
+	httpClient *http.Client
+	resources  []KubeApiResource
+}
+
+type KubeApiResource struct {
+	GroupVersion string
+	Name         string
+	Kind         string
+	Namespaced   bool
+	Verbs        []string
+}
+
+type KubeApiError struct {
+	Code    int
+	Reason  string
+	Message string
+}
+
+func (err *KubeApiError) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("kubernetes api returned %d %s", err.Code, err.Reason)
+	}
+
+	return err.Message
+}
+
+/*
+ * Selectors of workloads cannot be changed, e.g. a StatefulSet deployed before
+ * the env label was added to its selector is rejected.
+ */
+func isImmutableFieldError(err error) bool {
+	apiErr, ok := err.(*KubeApiError)
+
+	return ok && apiErr.Code == http.StatusUnprocessableEntity &&
+		(strings.Contains(apiErr.Message, "field is immutable") || strings.Contains(apiErr.Message, "updates to statefulset spec for fields other than"))
+}
+
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*KubeApiError)
+
+	return ok && apiErr.Code == http.StatusNotFound
+}
+
+func NewKubeApiClient(server string, token string, context string, resourceFilter ResourceFilter) (*KubeApiClient, error) {
+	client := &KubeApiClient{
+		Server:         server,
+		Token:          token,
+		Context:        context,
+		ResourceFilter: resourceFilter,
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if context != "" {
+		kubeConfig, err := ReadKubeConfig()
+
+		if err != nil {
+			return nil, err
+		}
+
+		contextConfig, err := kubeConfig.Resolve(context)
+
+		if err != nil {
+			return nil, err
+		}
+
+		client.Server = contextConfig.Server
+		client.Token = contextConfig.Token
+		tlsConfig = contextConfig.TLSConfig
+	}
+
+	if client.Server == "" {
+		return nil, errors.New("no kubernetes api server given")
+	}
+
+	client.Server = strings.TrimRight(client.Server, "/")
+	client.httpClient = &http.Client{
+		Timeout: HTTP_TIMEOUT,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+
+	return client, nil
+}
+
+func (client *KubeApiClient) Version() (string, error) {
+	var serverVersion struct {
+		GitVersion string `json:"gitVersion"`
+	}
+
+	err := client.request("GET", "/version", nil, "", &serverVersion)
+
+	if err != nil {
+		return "", err
+	}
+
+	return serverVersion.GitVersion, nil
+}
+
+func (client *KubeApiClient) Apply(definition string, namespace string, dryRun bool) (string, error) {
+	objects, err := UnmarshalYaml(definition)
+
+	if err != nil {
+		return "", err
+	}
+
+	output := make([]string, 0)
+
+	for _, object := range objects {
+		apiVersion, _ := object["apiVersion"].(string)
+		kind, _ := object["kind"].(string)
+		metadata, _ := object["metadata"].(map[interface{}]interface{})
+		name, _ := metadata["name"].(string)
+
+		resource, err := client.resourceForKind(apiVersion, kind)
+
+		if err != nil {
+			return strings.Join(output, "\n"), err
+		}
+
+		body, err := yaml.Marshal(object)
+
+		if err != nil {
+			return strings.Join(output, "\n"), err
+		}
+
+		query := url.Values{}
+		query.Set("fieldManager", FIELD_MANAGER)
+		query.Set("force", "true")
+
+		if dryRun {
+			query.Set("dryRun", "All")
+		}
+
+		path := resource.path(namespace, name) + "?" + query.Encode()
+		status, err := client.do("PATCH", path, body, "application/apply-patch+yaml", nil)
+
+		if isImmutableFieldError(err) {
+			return strings.Join(output, "\n"), fmt.Errorf(
+				"%s/%s: %v\nits selector or another immutable field changed, recreate it: kubectl delete %s %s --namespace %s --cascade=orphan",
+				resource.qualifiedName(), name, err, resource.qualifiedName(), name, namespace,
+			)
+		}
+
+		if err != nil {
+			return strings.Join(output, "\n"), fmt.Errorf("%s/%s: %v", resource.qualifiedName(), name, err)
+		}
+
+		action := "configured"
+		if status == http.StatusCreated {
+			action = "created"
+		}
+
+		output = append(output, fmt.Sprintf("%s/%s %s%s", resource.qualifiedName(), name, action, dryRunSuffix(dryRun)))
+	}
+
+	return strings.Join(output, "\n"), nil
+}
+
+func (client *KubeApiClient) Get(apiVersion string, kind string, namespace string, name string) (map[string]interface{}, error) {
+	resource, err := client.resourceForKind(apiVersion, kind)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var object map[string]interface{}
+
+	err = client.request("GET", resource.path(namespace, name), nil, "", &object)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return object, nil
+}
+
+func (client *KubeApiClient) Delete(apiVersion string, kind string, namespace string, name string, dryRun bool) (string, error) {
+	resource, err := client.resourceForKind(apiVersion, kind)
+
+	if err != nil {
+		return "", err
+	}
+
+	err = client.delete(resource, namespace, name, dryRun)
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s \"%s\" deleted%s", resource.qualifiedName(), name, dryRunSuffix(dryRun)), nil
+}
+
+func (client *KubeApiClient) List(apiVersion string, kind string, namespace string, labelSelector string) ([]map[string]interface{}, error) {
+	resource, err := client.resourceForKind(apiVersion, kind)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return client.listObjects(resource, namespace, labelSelector)
+}
+
+func (client *KubeApiClient) ListObjects(namespace string, labelSelector string) ([]map[string]interface{}, error) {
+	objects := make([]map[string]interface{}, 0)
+
+	resources, err := client.cleanableResources()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, resource := range resources {
+		items, err := client.listObjects(resource, namespace, labelSelector)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			if len(NestedSlice(item, "metadata", "ownerReferences")) == 0 {
+				objects = append(objects, item)
+			}
+		}
+	}
+
+	return objects, nil
+}
+
+func (client *KubeApiClient) GetDeployedBranchHashes(namespace string, labelList map[string]string) ([]string, error) {
+	branches := make([]string, 0)
+
+	selector := "branch_hash"
+	if len(labelList) > 0 {
+		selector += "," + LabelSelector(labelList)
+	}
+
+	resources, err := client.cleanableResources()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, resource := range resources {
+		items, err := client.list(resource, namespace, selector)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			if len(item.Metadata.OwnerReferences) == 0 {
+				branches = append(branches, item.Metadata.Labels["branch_hash"])
+			}
+		}
+	}
+
+	branches = Unique(branches)
+	branches = Filter(branches, func(v string) bool {
+		return v != ""
+	})
+
+	return branches, nil
+}
+
+func (client *KubeApiClient) DeleteObjectsByBranch(branchHash string, namespace string, labelList map[string]string, dryRun bool) (string, error) {
+	selector := map[string]string{}
+
+	for labelName, labelValue := range labelList {
+		selector[labelName] = labelValue
+	}
+
+	selector["branch_hash"] = branchHash
+
+	output := make([]string, 0)
+
+	resources, err := client.cleanableResources()
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, resource := range resources {
+		items, err := client.list(resource, namespace, LabelSelector(selector))
+
+		if err != nil {
+			return strings.Join(output, "\n"), err
+		}
+
+		for _, item := range items {
+			if len(item.Metadata.OwnerReferences) > 0 {
+				continue
+			}
+
+			err = client.delete(resource, namespace, item.Metadata.Name, dryRun)
+
+			if err != nil && !IsNotFound(err) {
+				return strings.Join(output, "\n"), err
+			}
+
+			output = append(output, fmt.Sprintf("%s \"%s\" deleted%s", resource.qualifiedName(), item.Metadata.Name, dryRunSuffix(dryRun)))
+		}
+	}
+
+	return strings.Join(output, "\n"), nil
+}
+
+type kubeApiObjectList struct {
+	Items []kubeApiObject `json:"items"`
+}
+
+type kubeApiObject struct {
+	Metadata struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace"`
+		Labels    map[string]string `json:"labels"`
+		// Only checked for presence
+		OwnerReferences []interface{} `json:"ownerReferences"`
+	} `json:"metadata"`
+}
+
+func (client *KubeApiClient) list(resource KubeApiResource, namespace string, labelSelector string) ([]kubeApiObject, error) {
+	var objectList kubeApiObjectList
+
+	query := url.Values{}
+	query.Set("labelSelector", labelSelector)
+
+	err := client.request("GET", resource.path(namespace, "")+"?"+query.Encode(), nil, "", &objectList)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return objectList.Items, nil
+}
+
+func (client *KubeApiClient) listObjects(resource KubeApiResource, namespace string, labelSelector string) ([]map[string]interface{}, error) {
+	var objectList struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+
+	query := url.Values{}
+	query.Set("labelSelector", labelSelector)
+
+	err := client.request("GET", resource.path(namespace, "")+"?"+query.Encode(), nil, "", &objectList)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Items of a list come without kind and apiVersion
+	for _, item := range objectList.Items {
+		item["apiVersion"] = resource.GroupVersion
+		item["kind"] = resource.Kind
+	}
+
+	return objectList.Items, nil
+}
+
+func (client *KubeApiClient) delete(resource KubeApiResource, namespace string, name string, dryRun bool) error {
+	deleteOptions := map[string]interface{}{
+		"kind":              "DeleteOptions",
+		"apiVersion":        "v1",
+		"propagationPolicy": "Background",
+	}
+
+	if dryRun {
+		deleteOptions["dryRun"] = []string{"All"}
+	}
+
+	body, err := json.Marshal(deleteOptions)
+
+	if err != nil {
+		return err
+	}
+
+	return client.request("DELETE", resource.path(namespace, name), body, "application/json", nil)
+}
+
+/*
+ * Looks up the resource serving the given kind in the given group version.
+ */
+func (client *KubeApiClient) resourceForKind(apiVersion string, kind string) (KubeApiResource, error) {
+	resources, err := client.groupVersionResources(apiVersion)
+
+	if err != nil {
+		return KubeApiResource{}, err
+	}
+
+	for _, resource := range resources {
+		if resource.Kind == kind {
+			return resource, nil
+		}
+	}
+
+	return KubeApiResource{}, fmt.Errorf("no resource of kind %s found in %s", kind, apiVersion)
+}
+
+/*
+ * Returns all namespaced resources of the preferred group versions which
+ * can be listed and deleted, restricted by the resource filter.
+ */
+func (client *KubeApiClient) cleanableResources() ([]KubeApiResource, error) {
+	resources, err := client.discover()
+
+	if err != nil {
+		return nil, err
+	}
+
+	cleanable := make([]KubeApiResource, 0)
+
+	for _, resource := range resources {
+		if !resource.Namespaced || !Contains(resource.Verbs, "list") || !Contains(resource.Verbs, "delete") {
+			continue
+		}
+
+		if client.ResourceFilter.Allows(resource) {
+			cleanable = append(cleanable, resource)
+		}
+	}
+
+	return cleanable, nil
+}
+
+func (client *KubeApiClient) discover() ([]KubeApiResource, error) {
+	if client.resources != nil {
+		return client.resources, nil
+	}
+
+	var coreVersions struct {
+		Versions []string `json:"versions"`
+	}
+
+	err := client.request("GET", "/api", nil, "", &coreVersions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var groups struct {
+		Groups []struct {
+			PreferredVersion struct {
+				GroupVersion string `json:"groupVersion"`
+			} `json:"preferredVersion"`
+		} `json:"groups"`
+	}
+
+	err = client.request("GET", "/apis", nil, "", &groups)
+
+	if err != nil {
+		return nil, err
+	}
+
+	groupVersions := make([]string, 0)
+
+	if len(coreVersions.Versions) > 0 {
+		groupVersions = append(groupVersions, coreVersions.Versions[0])
+	}
+
+	for _, group := range groups.Groups {
+		groupVersions = append(groupVersions, group.PreferredVersion.GroupVersion)
+	}
+
+	resources := make([]KubeApiResource, 0)
+
+	for _, groupVersion := range groupVersions {
+		groupVersionResources, err := client.groupVersionResources(groupVersion)
+
+		// Like kubectl, skip unavailable groups (e.g. aggregated apis
+		// without a running backend) instead of failing altogether
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: skipping api group %s: %v\n", groupVersion, err)
+			continue
+		}
+
+		resources = append(resources, groupVersionResources...)
+	}
+
+	client.resources = resources
+
+	return resources, nil
+}
+
+func (client *KubeApiClient) groupVersionResources(groupVersion string) ([]KubeApiResource, error) {
+	var resourceList struct {
+		Resources []struct {
+			Name       string   `json:"name"`
+			Kind       string   `json:"kind"`
+			Namespaced bool     `json:"namespaced"`
+			Verbs      []string `json:"verbs"`
+		} `json:"resources"`
+	}
+
+	err := client.request("GET", groupVersionPath(groupVersion), nil, "", &resourceList)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]KubeApiResource, 0)
+
+	for _, resource := range resourceList.Resources {
+		// Skip subresources like deployments/scale
+		if strings.Contains(resource.Name, "/") {
+			continue
+		}
+
+		resources = append(resources, KubeApiResource{
+			GroupVersion: groupVersion,
+			Name:         resource.Name,
+			Kind:         resource.Kind,
+			Namespaced:   resource.Namespaced,
+			Verbs:        resource.Verbs,
+		})
+	}
+
+	return resources, nil
+}
+
+func (client *KubeApiClient) request(method string, path string, body []byte, contentType string, result interface{}) error {
+	_, err := client.do(method, path, body, contentType, result)
+
+	return err
+}
+
+func (client *KubeApiClient) do(method string, path string, body []byte, contentType string, result interface{}) (int, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, client.Server+path, bodyReader)
+
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "kube-deployer/"+version())
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if client.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+client.Token)
+	}
+
+	resp, err := client.httpClient.Do(req)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &KubeApiError{
+			Code:   resp.StatusCode,
+			Reason: http.StatusText(resp.StatusCode),
+		}
+
+		// Most errors come as a Status object, everything else is kept as is.
+		var status struct {
+			Kind    string `json:"kind"`
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		}
+
+		if json.Unmarshal(responseBody, &status) == nil && status.Kind == "Status" {
+			apiErr.Reason = status.Reason
+			apiErr.Message = status.Message
+		} else {
+			apiErr.Message = strings.TrimSpace(string(responseBody))
+		}
+
+		return resp.StatusCode, apiErr
+	}
+
+	if result != nil {
+		err = json.Unmarshal(responseBody, result)
+	}
+
+	return resp.StatusCode, err
+}
+
+func (resource KubeApiResource) path(namespace string, name string) string {
+	path := groupVersionPath(resource.GroupVersion)
+
+	if resource.Namespaced {
+		path += "/namespaces/" + namespace
+	}
+
+	path += "/" + resource.Name
+
+	if name != "" {
+		path += "/" + name
+	}
+
+	return path
+}
+
+/*
+ * Name in the form kubectl prints it, e.g. deployment.apps or service.
+ */
+func (resource KubeApiResource) qualifiedName() string {
+	name := strings.ToLower(resource.Kind)
+
+	if strings.Contains(resource.GroupVersion, "/") {
+		name += "." + strings.Split(resource.GroupVersion, "/")[0]
+	}
+
+	return name
+}
+
+func (resource KubeApiResource) Matches(name string) bool {
+	return strings.EqualFold(name, resource.Name) ||
+		strings.EqualFold(name, resource.Kind) ||
+		strings.EqualFold(name, resource.Name+"."+strings.Split(resource.GroupVersion, "/")[0])
+}
+
+func (filter ResourceFilter) Allows(resource KubeApiResource) bool {
+	matchesAny := func(names []string) bool {
+		for _, name := range names {
+			if resource.Matches(name) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(filter.Include) > 0 {
+		return matchesAny(filter.Include) && !matchesAny(filter.Exclude)
+	}
+
+	return !matchesAny(DEFAULT_EXCLUDED_RESOURCES) && !matchesAny(filter.Exclude)
+}
+
+func groupVersionPath(groupVersion string) string {
+	if strings.Contains(groupVersion, "/") {
+		return "/apis/" + groupVersion
+	}
+
+	return "/api/" + groupVersion
+}
+
+func LabelSelector(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+
+	for name := range labels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	selector := make([]string, 0, len(names))
+
+	for _, name := range names {
+		selector = append(selector, name+"="+labels[name])
+	}
+
+	return strings.Join(selector, ",")
+}
+
+func dryRunSuffix(dryRun bool) string {
+	if dryRun {
+		return " (dry run)"
+	}
+
+	return ""
+}
+
+func newCertPool(pem []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in certificate authority data")
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FakeKubeClient keeps applied objects in memory, keyed by namespace, kind and name.
+type FakeKubeClient struct {
+	ServerVersion string
+	Objects       map[string]map[string]interface{}
+	// Status returned by Get, keyed by kind and name
+	Statuses map[string]map[string]interface{}
+}
+
+func NewFakeKubeClient() *FakeKubeClient {
+	return &FakeKubeClient{
+		ServerVersion: "v1.25.11",
+		Objects:       make(map[string]map[string]interface{}),
+		Statuses:      make(map[string]map[string]interface{}),
+	}
+}
+
+func (client *FakeKubeClient) Version() (string, error) {
+	return client.ServerVersion, nil
+}
+
+func (client *FakeKubeClient) Apply(definition string, namespace string, dryRun bool) (string, error) {
+	objects, err := UnmarshalYaml(definition)
+
+	if err != nil {
+		return "", err
+	}
+
+	output := make([]string, 0)
+
+	for _, object := range objects {
+		kind := object["kind"].(string)
+		name := object["metadata"].(map[interface{}]interface{})["name"].(string)
+		key := fakeObjectKey(namespace, kind, name)
+
+		action := "configured"
+		if _, ok := client.Objects[key]; !ok {
+			action = "created"
+		}
+
+		// Like the api server, turn stringData of secrets into data
+		if stringData, ok := object["stringData"].(map[interface{}]interface{}); ok {
+			data := map[interface{}]interface{}{}
+			for key, value := range stringData {
+				data[key] = base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(value)))
+			}
+			object["data"] = data
+			delete(object, "stringData")
+		}
+
+		if !dryRun {
+			client.Objects[key] = object
+		}
+
+		output = append(output, fmt.Sprintf("%s/%s %s%s", strings.ToLower(kind), name, action, dryRunSuffix(dryRun)))
+	}
+
+	return strings.Join(output, "\n"), nil
+}
+
+func (client *FakeKubeClient) Get(apiVersion string, kind string, namespace string, name string) (map[string]interface{}, error) {
+	object, ok := client.Objects[fakeObjectKey(namespace, kind, name)]
+
+	if !ok {
+		return nil, &KubeApiError{Code: 404, Reason: "NotFound", Message: fmt.Sprintf("%s \"%s\" not found", kind, name)}
+	}
+
+	object = NormalizeObject(object)
+
+	if status, ok := client.Statuses[kind+"/"+name]; ok {
+		object["status"] = status
+	}
+
+	return object, nil
+}
+
+func (client *FakeKubeClient) Delete(apiVersion string, kind string, namespace string, name string, dryRun bool) (string, error) {
+	key := fakeObjectKey(namespace, kind, name)
+
+	if _, ok := client.Objects[key]; !ok {
+		return "", &KubeApiError{Code: 404, Reason: "NotFound", Message: fmt.Sprintf("%s \"%s\" not found", kind, name)}
+	}
+
+	if !dryRun {
+		delete(client.Objects, key)
+	}
+
+	return fmt.Sprintf("%s \"%s\" deleted%s", strings.ToLower(kind), name, dryRunSuffix(dryRun)), nil
+}
+
+func (client *FakeKubeClient) List(apiVersion string, kind string, namespace string, labelSelector string) ([]map[string]interface{}, error) {
+	objects := make([]map[string]interface{}, 0)
+
+	for _, key := range client.keys(namespace, parseFakeSelector(labelSelector)) {
+		if client.Objects[key]["kind"] == kind {
+			objects = append(objects, NormalizeObject(client.Objects[key]))
+		}
+	}
+
+	return objects, nil
+}
+
+func (client *FakeKubeClient) ListObjects(namespace string, labelSelector string) ([]map[string]interface{}, error) {
+	objects := make([]map[string]interface{}, 0)
+
+	for _, key := range client.keys(namespace, parseFakeSelector(labelSelector)) {
+		object := NormalizeObject(client.Objects[key])
+
+		if status, ok := client.Statuses[NestedString(object, "kind")+"/"+NestedString(object, "metadata", "name")]; ok {
+			object["status"] = status
+		}
+
+		objects = append(objects, object)
+	}
+
+	return objects, nil
+}
+
+func (client *FakeKubeClient) GetDeployedBranchHashes(namespace string, labelList map[string]string) ([]string, error) {
+	branches := make([]string, 0)
+
+	for _, key := range client.keys(namespace, labelList) {
+		branches = append(branches, fakeObjectLabels(client.Objects[key])["branch_hash"])
+	}
+
+	branches = Unique(branches)
+	branches = Filter(branches, func(v string) bool {
+		return v != ""
+	})
+
+	return branches, nil
+}
+
+func (client *FakeKubeClient) DeleteObjectsByBranch(branchHash string, namespace string, labelList map[string]string, dryRun bool) (string, error) {
+	selector := map[string]string{"branch_hash": branchHash}
+
+	for labelName, labelValue := range labelList {
+		selector[labelName] = labelValue
+	}
+
+	output := make([]string, 0)
+
+	for _, key := range client.keys(namespace, selector) {
+		object := client.Objects[key]
+		kind := strings.ToLower(object["kind"].(string))
+		name := object["metadata"].(map[interface{}]interface{})["name"].(string)
+
+		if !dryRun {
+			delete(client.Objects, key)
+		}
+
+		output = append(output, fmt.Sprintf("%s \"%s\" deleted%s", kind, name, dryRunSuffix(dryRun)))
+	}
+
+	return strings.Join(output, "\n"), nil
+}
+
+func (client *FakeKubeClient) Object(namespace string, kind string, name string) map[string]interface{} {
+	return client.Objects[fakeObjectKey(namespace, kind, name)]
+}
+
+func (client *FakeKubeClient) keys(namespace string, selector map[string]string) []string {
+	keys := make([]string, 0)
+
+	for key, object := range client.Objects {
+		if !strings.HasPrefix(key, namespace+"/") {
+			continue
+		}
+
+		labels := fakeObjectLabels(object)
+		matches := true
+
+		for labelName, labelValue := range selector {
+			actualValue, ok := labels[labelName]
+
+			if !ok || (labelValue != "" && actualValue != labelValue) {
+				matches = false
+			}
+		}
+
+		if matches {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+/*
+ * Supports "name=value" and "name" (exists) requirements, the latter is
+ * returned with an empty value.
+ */
+func parseFakeSelector(labelSelector string) map[string]string {
+	selector := map[string]string{}
+
+	for _, requirement := range Filter(strings.Split(labelSelector, ","), func(v string) bool { return v != "" }) {
+		parts := strings.SplitN(requirement, "=", 2)
+
+		if len(parts) == 1 {
+			selector[parts[0]] = ""
+		} else {
+			selector[parts[0]] = parts[1]
+		}
+	}
+
+	return selector
+}
+
+func fakeObjectKey(namespace string, kind string, name string) string {
+	return namespace + "/" + kind + "/" + name
+}
+
+func fakeObjectLabels(object map[string]interface{}) map[string]string {
+	labels := map[string]string{}
+
+	metadata, _ := object["metadata"].(map[interface{}]interface{})
+	objectLabels, _ := metadata["labels"].(map[interface{}]interface{})
+
+	for labelName, labelValue := range objectLabels {
+		labels[fmt.Sprint(labelName)] = fmt.Sprint(labelValue)
+	}
+
+	return labels
+}
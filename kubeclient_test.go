@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testDiscoveryApi = `{"versions":["v1"]}`
+const testDiscoveryApis = `{"groups":[{"name":"apps","preferredVersion":{"groupVersion":"apps/v1"}},{"name":"batch","preferredVersion":{"groupVersion":"batch/v1"}}]}`
+const testDiscoveryCoreV1 = `{"resources":[
+	{"name":"services","kind":"Service","namespaced":true,"verbs":["create","delete","get","list","patch"]},
+	{"name":"services/status","kind":"Service","namespaced":true,"verbs":["get","patch"]},
+	{"name":"configmaps","kind":"ConfigMap","namespaced":true,"verbs":["create","delete","get","list","patch"]},
+	{"name":"events","kind":"Event","namespaced":true,"verbs":["create","delete","get","list","patch"]},
+	{"name":"bindings","kind":"Binding","namespaced":true,"verbs":["create"]},
+	{"name":"namespaces","kind":"Namespace","namespaced":false,"verbs":["create","delete","get","list","patch"]}
+]}`
+const testDiscoveryAppsV1 = `{"resources":[
+	{"name":"deployments","kind":"Deployment","namespaced":true,"verbs":["create","delete","get","list","patch"]},
+	{"name":"deployments/scale","kind":"Scale","namespaced":true,"verbs":["get","patch"]},
+	{"name":"replicasets","kind":"ReplicaSet","namespaced":true,"verbs":["create","delete","get","list","patch"]}
+]}`
+const testDiscoveryBatchV1 = `{"resources":[{"name":"cronjobs","kind":"CronJob","namespaced":true,"verbs":["create","delete","get","list","patch"]}]}`
+
+type testApiRequest struct {
+	Method      string
+	Path        string
+	Query       string
+	ContentType string
+	Body        string
+}
+
+func newTestApiServer(t *testing.T, handlers map[string]string) (*httptest.Server, *[]testApiRequest) {
+	requests := make([]testApiRequest, 0)
+
+	responses := map[string]string{
+		"GET /version":       `{"gitVersion":"v1.25.11"}`,
+		"GET /api":           testDiscoveryApi,
+		"GET /apis":          testDiscoveryApis,
+		"GET /api/v1":        testDiscoveryCoreV1,
+		"GET /apis/apps/v1":  testDiscoveryAppsV1,
+		"GET /apis/batch/v1": testDiscoveryBatchV1,
+	}
+
+	for route, response := range handlers {
+		responses[route] = response
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		requests = append(requests, testApiRequest{
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			Query:       r.URL.RawQuery,
+			ContentType: r.Header.Get("Content-Type"),
+			Body:        string(body),
+		})
+
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		response, ok := responses[r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery]
+
+		if !ok {
+			response, ok = responses[r.Method+" "+r.URL.Path]
+		}
+
+		if !ok {
+			response = `{"kind":"Items","items":[]}`
+
+			if r.Method != "GET" {
+				w.WriteHeader(http.StatusNotFound)
+				response = fmt.Sprintf(`{"kind":"Status","reason":"NotFound","message":"%s not found","code":404}`, r.URL.Path)
+			}
+		}
+
+		if response == "unavailable" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			response = `{"kind":"Status","reason":"ServiceUnavailable","message":"the server is currently unable to handle the request","code":503}`
+		}
+
+		if strings.HasPrefix(response, "invalid: ") {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			response = fmt.Sprintf(`{"kind":"Status","reason":"Invalid","message":%q,"code":422}`, strings.TrimPrefix(response, "invalid: "))
+		}
+
+		if response == "created" {
+			w.WriteHeader(http.StatusCreated)
+			response = "{}"
+		}
+
+		w.Write([]byte(response))
+	}))
+
+	return server, &requests
+}
+
+func TestKubeApiClientApply(t *testing.T) {
+	server, requests := newTestApiServer(t, map[string]string{
+		"PATCH /api/v1/namespaces/staging/services/master-web":          "created",
+		"PATCH /apis/apps/v1/namespaces/staging/deployments/master-web": "{}",
+	})
+	defer server.Close()
+
+	assert := assert.New(t)
+
+	kubeClient, err := NewKubeApiClient(server.URL, "secret", "", ResourceFilter{})
+	assert.Nil(err)
+
+	serverVersion, err := kubeClient.Version()
+	assert.Nil(err)
+	assert.Equal("v1.25.11", serverVersion)
+
+	definition := `
+apiVersion: v1
+kind: Service
+metadata:
+  name: master-web
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: master-web
+`
+
+	output, err := kubeClient.Apply(definition, "staging", true)
+
+	assert.Nil(err)
+	assert.Equal("service/master-web created (dry run)\ndeployment.apps/master-web configured (dry run)", output)
+
+	patches := make([]testApiRequest, 0)
+	for _, request := range *requests {
+		if request.Method == "PATCH" {
+			patches = append(patches, request)
+		}
+	}
+
+	assert.Len(patches, 2)
+	assert.Equal("application/apply-patch+yaml", patches[0].ContentType)
+	assert.Equal("dryRun=All&fieldManager=kube-deployer&force=true", patches[0].Query)
+	assert.Contains(patches[1].Body, "name: master-web")
+
+	_, err = kubeClient.Apply("apiVersion: v1\nkind: Secret\nmetadata:\n  name: foo\n", "staging", false)
+
+	assert.EqualError(err, "no resource of kind Secret found in v1")
+}
+
+func TestKubeApiClientApplyImmutableSelector(t *testing.T) {
+	server, _ := newTestApiServer(t, map[string]string{
+		"PATCH /apis/apps/v1/namespaces/staging/deployments/master-web": `invalid: Deployment.apps "master-web" is invalid: spec.selector: Invalid value: {"app":"web","env":"master"}: field is immutable`,
+	})
+	defer server.Close()
+
+	kubeClient, err := NewKubeApiClient(server.URL, "secret", "", ResourceFilter{})
+	assert.Nil(t, err)
+
+	_, err = kubeClient.Apply("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: master-web\n", "staging", false)
+
+	assert.EqualError(t, err, `deployment.apps/master-web: Deployment.apps "master-web" is invalid: spec.selector: Invalid value: {"app":"web","env":"master"}: field is immutable
+its selector or another immutable field changed, recreate it: kubectl delete deployment.apps master-web --namespace staging --cascade=orphan`)
+}
+
+func TestKubeApiClientClean(t *testing.T) {
+	server, requests := newTestApiServer(t, map[string]string{
+		"GET /apis/apps/v1/namespaces/staging/deployments": `{"items":[
+			{"metadata":{"name":"master-web","labels":{"branch_hash":"a"}}},
+			{"metadata":{"name":"feature-web","labels":{"branch_hash":"b"}}}
+		]}`,
+		"GET /apis/apps/v1/namespaces/staging/deployments?labelSelector=app%3Dweb%2Cbranch_hash%3Db": `{"items":[
+			{"metadata":{"name":"feature-web","labels":{"branch_hash":"b"}}}
+		]}`,
+		"GET /api/v1/namespaces/staging/services": `{"items":[{"metadata":{"name":"feature-web","labels":{"branch_hash":"b"}}}]}`,
+		"GET /apis/apps/v1/namespaces/staging/replicasets": `{"items":[
+			{"metadata":{"name":"feature-web-abc","labels":{"branch_hash":"c"},"ownerReferences":[{"kind":"Deployment"}]}}
+		]}`,
+		"DELETE /apis/apps/v1/namespaces/staging/deployments/feature-web": `{}`,
+		"DELETE /api/v1/namespaces/staging/services/feature-web":          `{}`,
+	})
+	defer server.Close()
+
+	assert := assert.New(t)
+
+	kubeClient, err := NewKubeApiClient(server.URL, "secret", "", ResourceFilter{})
+	assert.Nil(err)
+
+	branchHashes, err := kubeClient.GetDeployedBranchHashes("staging", map[string]string{})
+
+	assert.Nil(err)
+	assert.Equal([]string{"b", "a"}, branchHashes)
+
+	output, err := kubeClient.DeleteObjectsByBranch("b", "staging", map[string]string{"app": "web"}, false)
+
+	assert.Nil(err)
+	assert.Equal("service \"feature-web\" deleted\ndeployment.apps \"feature-web\" deleted", output)
+
+	for _, request := range *requests {
+		if request.Method == "DELETE" {
+			assert.Equal(`{"apiVersion":"v1","kind":"DeleteOptions","propagationPolicy":"Background"}`, request.Body)
+		}
+	}
+}
+
+func TestKubeApiClientUnavailableGroup(t *testing.T) {
+	server, _ := newTestApiServer(t, map[string]string{
+		"GET /apis": `{"groups":[
+			{"name":"apps","preferredVersion":{"groupVersion":"apps/v1"}},
+			{"name":"metrics.k8s.io","preferredVersion":{"groupVersion":"metrics.k8s.io/v1beta1"}}
+		]}`,
+		"GET /apis/metrics.k8s.io/v1beta1":                 "unavailable",
+		"GET /apis/apps/v1/namespaces/staging/deployments": `{"items":[{"metadata":{"name":"master-web","labels":{"branch_hash":"a"}}}]}`,
+	})
+	defer server.Close()
+
+	assert := assert.New(t)
+
+	kubeClient, err := NewKubeApiClient(server.URL, "secret", "", ResourceFilter{})
+	assert.Nil(err)
+
+	branchHashes, err := kubeClient.GetDeployedBranchHashes("staging", map[string]string{})
+
+	assert.Nil(err)
+	assert.Equal([]string{"a"}, branchHashes)
+}
+
+func TestKubeApiClientError(t *testing.T) {
+	server, _ := newTestApiServer(t, map[string]string{})
+	defer server.Close()
+
+	kubeClient, err := NewKubeApiClient(server.URL, "secret", "", ResourceFilter{})
+	assert.Nil(t, err)
+
+	_, err = kubeClient.DeleteObjectsByBranch("b", "staging", map[string]string{}, false)
+	assert.Nil(t, err)
+
+	err = kubeClient.request("GET", "/apis/apps/v1/namespaces/staging/deployments/foo", nil, "", nil)
+	assert.Nil(t, err)
+
+	err = kubeClient.request("DELETE", "/apis/apps/v1/namespaces/staging/deployments/foo", nil, "", nil)
+
+	assert.True(t, IsNotFound(err))
+	assert.EqualError(t, err, "/apis/apps/v1/namespaces/staging/deployments/foo not found")
+}
+
+func TestKubeApiClientResourceFilter(t *testing.T) {
+	server, _ := newTestApiServer(t, map[string]string{})
+	defer server.Close()
+
+	assert := assert.New(t)
+
+	testSet := map[string]ResourceFilter{
+		"services,configmaps,deployments,replicasets,cronjobs": {},
+		"configmaps,deployments,replicasets,cronjobs":          {Exclude: []string{"Service"}},
+		"events,deployments":                                   {Include: []string{"events", "deployments.apps"}},
+		"deployments":                                          {Include: []string{"Deployment", "cronjobs"}, Exclude: []string{"cronjobs.batch"}},
+	}
+
+	for expected, resourceFilter := range testSet {
+		kubeClient, err := NewKubeApiClient(server.URL, "secret", "", resourceFilter)
+		assert.Nil(err)
+
+		resources, err := kubeClient.cleanableResources()
+		assert.Nil(err)
+
+		names := make([]string, 0)
+		for _, resource := range resources {
+			names = append(names, resource.Name)
+		}
+
+		assert.Equal(expected, strings.Join(names, ","))
+	}
+}
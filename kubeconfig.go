@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ * Subset of the kubeconfig format needed to resolve a context into server,
+ * token and TLS settings. Credential plugins (exec, auth-provider) are not
+ * supported.
+ */
+type KubeConfig struct {
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string      `yaml:"token"`
+			TokenFile             string      `yaml:"tokenFile"`
+			ClientCertificate     string      `yaml:"client-certificate"`
+			ClientCertificateData string      `yaml:"client-certificate-data"`
+			ClientKey             string      `yaml:"client-key"`
+			ClientKeyData         string      `yaml:"client-key-data"`
+			Exec                  interface{} `yaml:"exec"`
+			AuthProvider          interface{} `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+
+	dir string
+}
+
+type KubeConfigContext struct {
+	Server    string
+	Token     string
+	TLSConfig *tls.Config
+}
+
+func ReadKubeConfig() (KubeConfig, error) {
+	var kubeConfig KubeConfig
+
+	filePath := os.Getenv("KUBECONFIG")
+
+	if filePath == "" {
+		filePath = filepath.Join(os.Getenv("HOME"), ".kube", "config")
+	} else {
+		// Only the first file of a KUBECONFIG list is read.
+		filePath = filepath.SplitList(filePath)[0]
+	}
+
+	file, err := ioutil.ReadFile(filePath)
+
+	if err != nil {
+		return kubeConfig, errors.New("Cannot read file " + filePath)
+	}
+
+	err = yaml.Unmarshal(file, &kubeConfig)
+
+	if err != nil {
+		return kubeConfig, fmt.Errorf("%s: %v", filePath, err)
+	}
+
+	kubeConfig.dir = filepath.Dir(filePath)
+
+	return kubeConfig, nil
+}
+
+func (kubeConfig KubeConfig) Resolve(contextName string) (KubeConfigContext, error) {
+	var result KubeConfigContext
+
+	clusterName, userName := "", ""
+	contextExist := false
+
+	for _, context := range kubeConfig.Contexts {
+		if context.Name == contextName {
+			clusterName = context.Context.Cluster
+			userName = context.Context.User
+			contextExist = true
+		}
+	}
+
+	if !contextExist {
+		return result, fmt.Errorf("context %s not present in kube config", contextName)
+	}
+
+	tlsConfig := &tls.Config{}
+	clusterExist := false
+
+	for _, cluster := range kubeConfig.Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+
+		clusterExist = true
+		result.Server = cluster.Cluster.Server
+		tlsConfig.InsecureSkipVerify = cluster.Cluster.InsecureSkipTLSVerify
+
+		caData, err := kubeConfig.readData(cluster.Cluster.CertificateAuthorityData, cluster.Cluster.CertificateAuthority)
+
+		if err != nil {
+			return result, err
+		}
+
+		if caData != nil {
+			tlsConfig.RootCAs, err = newCertPool(caData)
+
+			if err != nil {
+				return result, fmt.Errorf("cluster %s: %v", clusterName, err)
+			}
+		}
+	}
+
+	if !clusterExist {
+		return result, fmt.Errorf("cluster %s of context %s not present in kube config", clusterName, contextName)
+	}
+
+	for _, user := range kubeConfig.Users {
+		if user.Name != userName {
+			continue
+		}
+
+		if user.User.Exec != nil || user.User.AuthProvider != nil {
+			return result, fmt.Errorf("user %s uses a credential plugin, which is not supported. Please pass a token instead", userName)
+		}
+
+		result.Token = user.User.Token
+
+		if result.Token == "" && user.User.TokenFile != "" {
+			token, err := ioutil.ReadFile(kubeConfig.path(user.User.TokenFile))
+
+			if err != nil {
+				return result, err
+			}
+
+			result.Token = strings.TrimSpace(string(token))
+		}
+
+		certData, err := kubeConfig.readData(user.User.ClientCertificateData, user.User.ClientCertificate)
+
+		if err != nil {
+			return result, err
+		}
+
+		keyData, err := kubeConfig.readData(user.User.ClientKeyData, user.User.ClientKey)
+
+		if err != nil {
+			return result, err
+		}
+
+		if certData != nil && keyData != nil {
+			certificate, err := tls.X509KeyPair(certData, keyData)
+
+			if err != nil {
+				return result, fmt.Errorf("user %s: %v", userName, err)
+			}
+
+			tlsConfig.Certificates = []tls.Certificate{certificate}
+		}
+	}
+
+	result.TLSConfig = tlsConfig
+
+	return result, nil
+}
+
+/*
+ * Returns the decoded inline data if present, otherwise the content of the
+ * referenced file. Relative file paths are resolved against the kube config dir.
+ */
+func (kubeConfig KubeConfig) readData(data string, file string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+
+	if file != "" {
+		return ioutil.ReadFile(kubeConfig.path(file))
+	}
+
+	return nil, nil
+}
+
+func (kubeConfig KubeConfig) path(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+
+	return filepath.Join(kubeConfig.dir, file)
+}
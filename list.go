@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+ * An env as found in the cluster: all objects carrying the same env label.
+ */
+type EnvSummary struct {
+	Env        string         `json:"env"`
+	Branch     string         `json:"branch"`
+	BranchHash string         `json:"branchHash"`
+	Versions   []string       `json:"versions"`
+	Objects    map[string]int `json:"objects"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	Workloads  int            `json:"workloads"`
+	Ready      int            `json:"ready"`
+}
+
+func (summary EnvSummary) ObjectCount() int {
+	count := 0
+
+	for _, kindCount := range summary.Objects {
+		count += kindCount
+	}
+
+	return count
+}
+
+/*
+ * Groups the live objects of the namespace by env. Release records are not
+ * counted as objects.
+ */
+func ListEnvs(kubeClient KubeClient, namespace string, labelList map[string]string) ([]EnvSummary, error) {
+	selector := "env"
+	if len(labelList) > 0 {
+		selector += "," + LabelSelector(labelList)
+	}
+
+	objects, err := kubeClient.ListObjects(namespace, selector)
+
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := map[string]*EnvSummary{}
+
+	for _, object := range objects {
+		env := NestedString(object, "metadata", "labels", "env")
+
+		summary, ok := summaries[env]
+		if !ok {
+			summary = &EnvSummary{Env: env, Versions: []string{}, Objects: map[string]int{}}
+			summaries[env] = summary
+		}
+
+		if branch := NestedString(object, "metadata", "annotations", BRANCH_ANNOTATION); branch != "" {
+			summary.Branch = branch
+		} else if summary.Branch == "" {
+			summary.Branch = NestedString(object, "metadata", "labels", "branch")
+		}
+
+		if summary.BranchHash == "" {
+			summary.BranchHash = NestedString(object, "metadata", "labels", "branch_hash")
+		}
+
+		if NestedString(object, "metadata", "labels", RELEASE_LABEL) == RELEASE_LABEL_VALUE {
+			continue
+		}
+
+		kind := NestedString(object, "kind")
+		summary.Objects[kind]++
+
+		if version := NestedString(object, "metadata", "labels", "version"); version != "" {
+			summary.Versions = Unique(append(summary.Versions, version))
+		}
+
+		createdAt, err := time.Parse(time.RFC3339, NestedString(object, "metadata", "creationTimestamp"))
+
+		if err == nil && (summary.CreatedAt.IsZero() || createdAt.Before(summary.CreatedAt)) {
+			summary.CreatedAt = createdAt
+		}
+
+		if IsRolloutKind(kind) {
+			summary.Workloads++
+
+			if GetRolloutStatus(kind, object).Done {
+				summary.Ready++
+			}
+		}
+	}
+
+	envs := make([]EnvSummary, 0, len(summaries))
+
+	for _, summary := range summaries {
+		sort.Strings(summary.Versions)
+		envs = append(envs, *summary)
+	}
+
+	sort.Slice(envs, func(i, j int) bool {
+		return envs[i].Env < envs[j].Env
+	})
+
+	return envs, nil
+}
+
+func FormatEnvsTable(envs []EnvSummary, at time.Time) string {
+	var buffer bytes.Buffer
+
+	writer := tabwriter.NewWriter(&buffer, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(writer, "ENV\tBRANCH\tVERSION\tOBJECTS\tREADY\tAGE")
+
+	for _, env := range envs {
+		versions := strings.Join(env.Versions, ",")
+		if versions == "" {
+			versions = "-"
+		}
+
+		ready := "-"
+		if env.Workloads > 0 {
+			ready = fmt.Sprintf("%d/%d", env.Ready, env.Workloads)
+		}
+
+		age := "-"
+		if !env.CreatedAt.IsZero() {
+			age = formatAge(at.Sub(env.CreatedAt))
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%s\t%s\n", env.Env, env.Branch, versions, env.ObjectCount(), ready, age)
+	}
+
+	writer.Flush()
+
+	return strings.TrimRight(buffer.String(), "\n")
+}
+
+func FormatEnvsJson(envs []EnvSummary) (string, error) {
+	output, err := json.MarshalIndent(envs, "", "  ")
+
+	return string(output), err
+}
+
+/*
+ * Formats an age like kubectl does: 45s, 12m, 5h, 3d.
+ */
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return fmt.Sprintf("%ds", int(age.Seconds()))
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	}
+
+	return fmt.Sprintf("%dd", int(age.Hours()/24))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestListEnvs(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{"app.yml": testAppTemplate})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+	kubeClient := NewFakeKubeClient()
+
+	for _, branch := range []string{"master", "feature/login"} {
+		err := deploy(kubeClient, testDeployerSpec(projectDir, branch), DeployOptions{})
+		assert.Nil(err)
+	}
+
+	createdAt := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, object := range kubeClient.Objects {
+		object["metadata"].(map[interface{}]interface{})["creationTimestamp"] = createdAt.Format(time.RFC3339)
+	}
+
+	kubeClient.Statuses["Deployment/master-web"] = map[string]interface{}{
+		"replicas":          1,
+		"updatedReplicas":   1,
+		"availableReplicas": 1,
+	}
+
+	envs, err := ListEnvs(kubeClient, "staging", map[string]string{})
+
+	assert.Nil(err)
+	assert.Len(envs, 2)
+
+	assert.Equal("featurelogin", envs[0].Env)
+	assert.Equal("feature/login", envs[0].Branch)
+	assert.Equal(MD5("featurelogin"), envs[0].BranchHash)
+	assert.Equal([]string{"42"}, envs[0].Versions)
+	assert.Equal(map[string]int{"Deployment": 1, "Service": 1}, envs[0].Objects)
+	assert.Equal(0, envs[0].Ready)
+	assert.Equal(1, envs[0].Workloads)
+
+	assert.Equal("master", envs[1].Env)
+	assert.Equal(1, envs[1].Ready)
+	assert.Equal(createdAt, envs[1].CreatedAt)
+
+	table := FormatEnvsTable(envs, createdAt.Add(3*24*time.Hour))
+
+	assert.Equal(
+		"ENV            BRANCH          VERSION   OBJECTS   READY   AGE\n"+
+			"featurelogin   feature/login   42        2         0/1     3d\n"+
+			"master         master          42        2         1/1     3d",
+		table,
+	)
+
+	output, err := FormatEnvsJson(envs[1:])
+
+	assert.Nil(err)
+	assert.Contains(output, `"env": "master"`)
+	assert.Contains(output, `"ready": 1`)
+}
+
+func TestFormatAge(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("45s", formatAge(45*time.Second))
+	assert.Equal("12m", formatAge(12*time.Minute+5*time.Second))
+	assert.Equal("30h", formatAge(30*time.Hour))
+	assert.Equal("3d", formatAge(80*time.Hour))
+}
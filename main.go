@@ -2,17 +2,32 @@ package main
 
 import (
 	"fmt"
+	"github.com/fatih/color"
 	"gopkg.in/urfave/cli.v1"
 	"gopkg.in/yaml.v2"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 var __VERSION__ string
 
 const K8S_SERVICE = "Service"
 const K8S_DEPLOYMENT = "Deployment"
+const K8S_STATEFULSET = "StatefulSet"
+const K8S_DAEMONSET = "DaemonSet"
+const K8S_JOB = "Job"
+const K8S_REPLICASET = "ReplicaSet"
+const K8S_CRONJOB = "CronJob"
+const K8S_POD = "Pod"
+const K8S_INGRESS = "Ingress"
+const K8S_HPA = "HorizontalPodAutoscaler"
+const K8S_CONFIGMAP = "ConfigMap"
+const K8S_SECRET = "Secret"
+const K8S_PVC = "PersistentVolumeClaim"
 
 const PROJECT_DIR_FLAG = "project-dir"
 const TAG_FLAG = "tag"
@@ -28,6 +43,16 @@ const DRY_RUN_FLAG = "dry-run"
 const VERBOSE_FLAG = "verbose"
 const TOKEN_FLAG = "token"
 const CONTEXT_FLAG = "context"
+const TIMEOUT_FLAG = "timeout"
+const ROLLBACK_ON_FAILURE_FLAG = "rollback-on-failure"
+const TO_VERSION_FLAG = "to-version"
+const PRUNE_FLAG = "prune"
+const EXPIRED_FLAG = "expired"
+const OUTPUT_FLAG = "output"
+const VALUES_FLAG = "values"
+const SET_FLAG = "set"
+const RENDERING_FLAG = "rendering"
+const SECRETS_KEY_FILE_FLAG = "secrets-key-file"
 
 var projectDirFlag = cli.StringFlag{
 	Name:  PROJECT_DIR_FLAG,
@@ -71,7 +96,7 @@ var serverFlag = cli.StringFlag{
 }
 var dryRunFlag = cli.BoolFlag{
 	Name:  DRY_RUN_FLAG,
-	Usage: "Use kubectl --dry-run",
+	Usage: "Send all changes as server side dry run",
 }
 var verboseFlag = cli.BoolFlag{
 	Name:  VERBOSE_FLAG,
@@ -83,7 +108,49 @@ var tokenFlag = cli.StringFlag{
 }
 var contextFlag = cli.StringFlag{
 	Name:  CONTEXT_FLAG,
-	Usage: "Kube context. Will switch to this context before runnig any kubectl command",
+	Usage: "Kube context. Server and credentials will be read from this context of your kube config",
+}
+var timeoutFlag = cli.DurationFlag{
+	Name:  TIMEOUT_FLAG,
+	Value: DEFAULT_ROLLOUT_TIMEOUT,
+	Usage: "How long to wait for Deployments, StatefulSets, DaemonSets and Jobs to become available. 0 disables waiting.",
+}
+var toVersionFlag = cli.StringFlag{
+	Name:  TO_VERSION_FLAG,
+	Usage: "Version (tag) to roll back to. Defaults to the release deployed before the current one.",
+}
+var pruneFlag = cli.BoolFlag{
+	Name:  PRUNE_FLAG,
+	Usage: "Delete objects of the env and branch which are not part of the templates anymore",
+}
+var expiredFlag = cli.BoolFlag{
+	Name:  EXPIRED_FLAG,
+	Usage: "Also delete envs whose ttl has passed, even if their branch still exists",
+}
+var valuesFlag = cli.StringSliceFlag{
+	Name:  VALUES_FLAG,
+	Usage: "Yaml file with template vars (context.vars). Can be repeated, later files win.",
+}
+var setFlag = cli.StringSliceFlag{
+	Name:  SET_FLAG,
+	Usage: "Template var in format key=value, nested keys separated by dots. Wins over vars of config and values files.",
+}
+var renderingFlag = cli.StringFlag{
+	Name:  RENDERING_FLAG,
+	Usage: "yaml renders the parsed templates, text renders the template files before parsing. Overrides rendering of the config file.",
+}
+var secretsKeyFileFlag = cli.StringFlag{
+	Name:  SECRETS_KEY_FILE_FLAG,
+	Usage: "File with the key of the secrets file. Alternative it will read the KUBE_DEPLOYER_SECRETS_KEY or KUBE_DEPLOYER_SECRETS_KEY_FILE env variable.",
+}
+var outputFlag = cli.StringFlag{
+	Name:  OUTPUT_FLAG,
+	Value: "table",
+	Usage: "Output format, table or json",
+}
+var rollbackOnFailureFlag = cli.BoolFlag{
+	Name:  ROLLBACK_ON_FAILURE_FLAG,
+	Usage: "Restore the previously applied objects if the rollout fails or times out",
 }
 
 func main() {
@@ -103,15 +170,28 @@ func main() {
 				branchFlag,
 				templateFlag,
 				containerFlag,
+				valuesFlag,
+				setFlag,
+				renderingFlag,
+				secretsKeyFileFlag,
 				serverFlag,
 				dryRunFlag,
 				verboseFlag,
 				tokenFlag,
 				contextFlag,
+				timeoutFlag,
+				rollbackOnFailureFlag,
+				pruneFlag,
 			},
 			Action: func(c *cli.Context) error {
-				dryRun := c.Bool(DRY_RUN_FLAG)
-				verbose := c.Bool(VERBOSE_FLAG)
+				deployOptions := DeployOptions{
+					DryRun:            c.Bool(DRY_RUN_FLAG),
+					Verbose:           c.Bool(VERBOSE_FLAG),
+					Timeout:           c.Duration(TIMEOUT_FLAG),
+					RollbackOnFailure: c.Bool(ROLLBACK_ON_FAILURE_FLAG),
+					Prune:             c.Bool(PRUNE_FLAG),
+				}
+
 				token := c.String(TOKEN_FLAG)
 				context := c.String(CONTEXT_FLAG)
 
@@ -135,7 +215,13 @@ func main() {
 				deployerSpec.Cluster.Token = clusterApiToken
 				deployerSpec.Cluster.Context = context
 
-				deploy(deployerSpec, dryRun, verbose)
+				kubeClient := newKubeClient(deployerSpec.Cluster.Host, clusterApiToken, context, deployerSpec.ResourceFilter)
+
+				err = deploy(kubeClient, deployerSpec, deployOptions)
+
+				if err != nil {
+					log.Fatalf("error: %v", err)
+				}
 
 				return nil
 			},
@@ -151,6 +237,10 @@ func main() {
 				branchFlag,
 				templateFlag,
 				containerFlag,
+				valuesFlag,
+				setFlag,
+				renderingFlag,
+				secretsKeyFileFlag,
 				serverFlag,
 			},
 			Action: func(c *cli.Context) error {
@@ -172,6 +262,127 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:  "validate",
+			Usage: "Check the config file, its templates and render every target",
+			Flags: []cli.Flag{
+				projectDirFlag,
+				secretsKeyFileFlag,
+			},
+			Action: func(c *cli.Context) error {
+				projectDir := c.String(PROJECT_DIR_FLAG)
+
+				if projectDir == "" {
+					projectDir = "."
+				}
+
+				validationErrors := ValidateProject(projectDir, c.String(SECRETS_KEY_FILE_FLAG))
+
+				for _, validationError := range validationErrors {
+					fmt.Println(validationError.Error())
+				}
+
+				if len(validationErrors) > 0 {
+					os.Exit(1)
+				}
+
+				fmt.Printf("%s is valid\n", DEFAULT_DEPLOYER_YAML)
+
+				return nil
+			},
+		},
+		{
+			Name:  "migrate",
+			Usage: "Rewrite the config file in the latest spec version, keeping comments",
+			Flags: []cli.Flag{
+				projectDirFlag,
+				dryRunFlag,
+			},
+			Action: func(c *cli.Context) error {
+				projectDir := c.String(PROJECT_DIR_FLAG)
+
+				if projectDir == "" {
+					projectDir = "."
+				}
+
+				filePath := filepath.Join(projectDir, DEFAULT_DEPLOYER_YAML)
+				content, err := ioutil.ReadFile(filePath)
+
+				if err != nil {
+					log.Fatalf("error: %v", err)
+				}
+
+				migrated, err := MigrateConfigFile(content)
+
+				if err != nil {
+					log.Fatalf("error: %v", err)
+				}
+
+				if migrated == nil {
+					fmt.Printf("%s is already version %d\n", DEFAULT_DEPLOYER_YAML, DEPLOYER_SPEC_VERSION)
+					return nil
+				}
+
+				if c.Bool(DRY_RUN_FLAG) {
+					fmt.Print(string(migrated))
+					return nil
+				}
+
+				err = ioutil.WriteFile(filePath, migrated, 0644)
+
+				if err != nil {
+					log.Fatalf("error: %v", err)
+				}
+
+				fmt.Printf("%s migrated to version %d\n", DEFAULT_DEPLOYER_YAML, DEPLOYER_SPEC_VERSION)
+
+				return nil
+			},
+		},
+		{
+			Name:  "diff",
+			Usage: "Show the changes deploy would make to the live objects",
+			Flags: []cli.Flag{
+				projectDirFlag,
+				tagFlag,
+				clusterFlag,
+				namespaceFlag,
+				envFlag,
+				branchFlag,
+				templateFlag,
+				containerFlag,
+				valuesFlag,
+				setFlag,
+				renderingFlag,
+				secretsKeyFileFlag,
+				serverFlag,
+				tokenFlag,
+				contextFlag,
+			},
+			Action: func(c *cli.Context) error {
+				var deployerSpec DeployerSpec
+				err := deployerSpec.FromCliContext(c)
+
+				if err != nil {
+					log.Fatalf("error: %v", err)
+				}
+
+				kubeClient := newKubeClientFromCli(c, deployerSpec.Cluster.Host, deployerSpec.ResourceFilter)
+
+				output, changed, err := diff(kubeClient, deployerSpec)
+
+				if err != nil {
+					log.Fatalf("error: %v", err)
+				}
+
+				if changed {
+					fmt.Println(output)
+					os.Exit(1)
+				}
+
+				return nil
+			},
+		},
 		{
 			Name: "clean",
 			Flags: []cli.Flag{
@@ -183,13 +394,15 @@ func main() {
 				tokenFlag,
 				contextFlag,
 				dryRunFlag,
+				expiredFlag,
 			},
 			Action: func(c *cli.Context) error {
 				projectDir := c.String(PROJECT_DIR_FLAG)
 				cluster := c.String(CLUSTER_FLAG)
-				token := c.String(TOKEN_FLAG)
-				dryRun := c.Bool(DRY_RUN_FLAG)
-				context := c.String(CONTEXT_FLAG)
+				cleanOptions := CleanOptions{
+					DryRun:  c.Bool(DRY_RUN_FLAG),
+					Expired: c.Bool(EXPIRED_FLAG),
+				}
 
 				if projectDir == "" {
 					projectDir = "."
@@ -197,50 +410,193 @@ func main() {
 
 				var server = c.String(SERVER_FLAG)
 				var namespace = c.String(NAMESPACE_FLAG)
-				var labels = c.StringSlice(LABEL_FLAG)
-				var labelList = make(map[string]string)
-
-				for _, labelText := range labels {
-					if len(labelText) == 0 {
-						fmt.Println("Empty label flag detected.")
-						os.Exit(1)
-					}
-					labelParts := strings.Split(labelText, "=")
-					if len(labelParts) < 2 || (len(labelParts[0]) == 0 || len(labelParts[1]) == 0) {
-						fmt.Println("Invalid label flag detected.")
-						os.Exit(1)
-					}
-					labelList[labelParts[0]] = labelParts[1]
-				}
-
-				if cluster == "" && server == "" {
-					fmt.Println("Please specify either the cluster or server flag.")
-					os.Exit(1)
+				var labelList = labelListFromCli(c)
+
+				var deployerConfigFile DeployerConfigFile
+				err := deployerConfigFile.ReadOptionalFileFromFile(projectDir)
+
+				if err != nil {
+					log.Fatalf("error: %v", err)
 				}
 
-				if cluster != "" {
-					var deployerConfigFile DeployerConfigFile
-					err := deployerConfigFile.ReadFileFromFile(projectDir)
+				if deployerConfigFile.Project != "" {
+					labelList["project"] = MakeUrlSlug(deployerConfigFile.Project, DNS_MAX_LENGTH)
+				}
 
-					if err != nil {
-						log.Fatalf("error: %v", err)
-					}
+				cleanOptions.Protect, err = deployerConfigFile.Clean.ProtectRules()
 
-					server = deployerConfigFile.Clusters[cluster].Host
+				if err != nil {
+					log.Fatalf("error: %v", err)
 				}
 
-				var clusterApiToken string
-				if token == "" {
-					clusterApiToken = os.Getenv("KUBE_TOKEN")
-				} else {
-					clusterApiToken = token
+				kubeClient := newKubeClientFromCli(c, clusterServer(projectDir, cluster, server), deployerConfigFile.Clean.ResourceFilter())
+
+				err = clean(kubeClient, namespace, BranchHashes(projectDir), labelList, cleanOptions)
+
+				if err != nil {
+					log.Fatalf("error: %v", err)
 				}
 
-				if clusterApiToken == "" && context == "" {
-					log.Fatal("Please provide a Kubernetes access token or context.")
+				return nil
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "Show the envs deployed to a namespace",
+			Flags: []cli.Flag{
+				projectDirFlag,
+				clusterFlag,
+				namespaceFlag,
+				labelFlag,
+				serverFlag,
+				tokenFlag,
+				contextFlag,
+				outputFlag,
+			},
+			Action: func(c *cli.Context) error {
+				projectDir := c.String(PROJECT_DIR_FLAG)
+
+				if projectDir == "" {
+					projectDir = "."
+				}
+
+				var labelList = labelListFromCli(c)
+
+				var deployerConfigFile DeployerConfigFile
+				err := deployerConfigFile.ReadOptionalFileFromFile(projectDir)
+
+				if err != nil {
+					log.Fatalf("error: %v", err)
 				}
 
-				clean(projectDir, server, clusterApiToken, namespace, context, labelList, dryRun)
+				if deployerConfigFile.Project != "" {
+					labelList["project"] = MakeUrlSlug(deployerConfigFile.Project, DNS_MAX_LENGTH)
+				}
+
+				kubeClient := newKubeClientFromCli(c, clusterServer(projectDir, c.String(CLUSTER_FLAG), c.String(SERVER_FLAG)), deployerConfigFile.Clean.ResourceFilter())
+
+				envs, err := ListEnvs(kubeClient, c.String(NAMESPACE_FLAG), labelList)
+
+				if err != nil {
+					log.Fatalf("error: %v", err)
+				}
+
+				var output string
+
+				switch c.String(OUTPUT_FLAG) {
+				case "table":
+					output = FormatEnvsTable(envs, time.Now())
+				case "json":
+					output, err = FormatEnvsJson(envs)
+				default:
+					err = fmt.Errorf("unknown output format %s", c.String(OUTPUT_FLAG))
+				}
+
+				if err != nil {
+					log.Fatalf("error: %v", err)
+				}
+
+				fmt.Println(output)
+
+				return nil
+			},
+		},
+		{
+			Name:  "secrets",
+			Usage: "Manage encrypted secrets files",
+			Subcommands: []cli.Command{
+				{
+					Name:      "encrypt",
+					Usage:     "Encrypt secrets read from STDIN into the file",
+					ArgsUsage: "<file>",
+					Flags: []cli.Flag{
+						secretsKeyFileFlag,
+					},
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							log.Fatal("error: the secrets file is required")
+						}
+
+						err := encryptSecretsFile(c.Args().First(), c.String(SECRETS_KEY_FILE_FLAG), os.Stdin)
+
+						if err != nil {
+							log.Fatalf("error: %v", err)
+						}
+
+						return nil
+					},
+				},
+				{
+					Name:      "edit",
+					Usage:     "Decrypt the file, open it in $EDITOR and encrypt it again",
+					ArgsUsage: "<file>",
+					Flags: []cli.Flag{
+						secretsKeyFileFlag,
+					},
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							log.Fatal("error: the secrets file is required")
+						}
+
+						err := editSecretsFile(c.Args().First(), c.String(SECRETS_KEY_FILE_FLAG))
+
+						if err != nil {
+							log.Fatalf("error: %v", err)
+						}
+
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "rollback",
+			Usage: "Restore all objects of an env to an earlier release",
+			Flags: []cli.Flag{
+				projectDirFlag,
+				clusterFlag,
+				namespaceFlag,
+				envFlag,
+				serverFlag,
+				tokenFlag,
+				contextFlag,
+				toVersionFlag,
+				dryRunFlag,
+				verboseFlag,
+				timeoutFlag,
+			},
+			Action: func(c *cli.Context) error {
+				projectDir := c.String(PROJECT_DIR_FLAG)
+				namespace := c.String(NAMESPACE_FLAG)
+				env := c.String(ENV_FLAG)
+
+				if projectDir == "" {
+					projectDir = "."
+				}
+
+				if namespace == "" || env == "" {
+					fmt.Println("Please specify the namespace and env flag.")
+					os.Exit(1)
+				}
+
+				var deployerConfigFile DeployerConfigFile
+				err := deployerConfigFile.ReadOptionalFileFromFile(projectDir)
+
+				if err != nil {
+					log.Fatalf("error: %v", err)
+				}
+
+				kubeClient := newKubeClientFromCli(c, clusterServer(projectDir, c.String(CLUSTER_FLAG), c.String(SERVER_FLAG)), deployerConfigFile.Clean.ResourceFilter())
+
+				err = rollback(kubeClient, namespace, MakeUrlSlug(deployerConfigFile.Project, DNS_MAX_LENGTH), MakeUrlSlug(env, DNS_MAX_LENGTH), c.String(TO_VERSION_FLAG), DeployOptions{
+					DryRun:  c.Bool(DRY_RUN_FLAG),
+					Verbose: c.Bool(VERBOSE_FLAG),
+					Timeout: c.Duration(TIMEOUT_FLAG),
+				})
+
+				if err != nil {
+					log.Fatalf("error: %v", err)
+				}
 
 				return nil
 			},
@@ -250,21 +606,89 @@ func main() {
 	app.Run(os.Args)
 }
 
-func clean(projectDir string, host string, token string, namespace string, context string, labelList map[string]string, dryRun bool) {
-	kubectl := KubeClient{
-		Token:   token,
-		Server:  host,
-		Verbose: false,
-		Context: context,
+/*
+ * Returns the host of the cluster from the config file or the server flag.
+ */
+func clusterServer(projectDir string, cluster string, server string) string {
+	if cluster == "" && server == "" {
+		fmt.Println("Please specify either the cluster or server flag.")
+		os.Exit(1)
+	}
+
+	if cluster != "" {
+		var deployerConfigFile DeployerConfigFile
+		err := deployerConfigFile.ReadFileFromFile(projectDir)
+
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+
+		server = deployerConfigFile.Clusters[cluster].Host
+	}
+
+	return server
+}
+
+func newKubeClientFromCli(c *cli.Context, server string, resourceFilter ResourceFilter) KubeClient {
+	token := c.String(TOKEN_FLAG)
+	context := c.String(CONTEXT_FLAG)
+
+	var clusterApiToken string
+	if token == "" {
+		clusterApiToken = os.Getenv("KUBE_TOKEN")
+	} else {
+		clusterApiToken = token
+	}
+
+	if clusterApiToken == "" && context == "" {
+		log.Fatal("Please provide a Kubernetes access token or context.")
+	}
+
+	return newKubeClient(server, clusterApiToken, context, resourceFilter)
+}
+
+func newKubeClient(server string, token string, context string, resourceFilter ResourceFilter) KubeClient {
+	kubeClient, err := NewKubeApiClient(server, token, context, resourceFilter)
+
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	return kubeClient
+}
+
+func labelListFromCli(c *cli.Context) map[string]string {
+	var labelList = make(map[string]string)
+
+	for _, labelText := range c.StringSlice(LABEL_FLAG) {
+		if len(labelText) == 0 {
+			fmt.Println("Empty label flag detected.")
+			os.Exit(1)
+		}
+		labelParts := strings.Split(labelText, "=")
+		if len(labelParts) < 2 || (len(labelParts[0]) == 0 || len(labelParts[1]) == 0) {
+			fmt.Println("Invalid label flag detected.")
+			os.Exit(1)
+		}
+		labelList[labelParts[0]] = labelParts[1]
 	}
 
-	deployedBranchHashes, err := kubectl.GetDeployedBranchHashes(namespace)
+	return labelList
+}
+
+type CleanOptions struct {
+	DryRun  bool
+	Expired bool
+	Protect ProtectRules
+}
+
+func clean(kubeClient KubeClient, namespace string, projectBranchHashes map[string]string, labelList map[string]string, options CleanOptions) error {
+	deployedBranchHashes, err := kubeClient.GetDeployedBranchHashes(namespace, labelList)
 
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	projectBranchHashes := BranchHashes(projectDir)
 	branchesHashesToDelete := []string{}
 
 	for _, branchHash := range deployedBranchHashes {
@@ -274,71 +698,241 @@ func clean(projectDir string, host string, token string, namespace string, conte
 	}
 
 	for _, branchHashToDelete := range branchesHashesToDelete {
-		output, err := kubectl.DeleteObjectsByBranch(branchHashToDelete, namespace, labelList, dryRun)
+		selector := map[string]string{"branch_hash": branchHashToDelete}
+
+		for labelName, labelValue := range labelList {
+			selector[labelName] = labelValue
+		}
+
+		objects, err := kubeClient.ListObjects(namespace, LabelSelector(selector))
+
+		if err != nil {
+			return err
+		}
+
+		if !options.Protect.Any(objects) {
+			output, err := kubeClient.DeleteObjectsByBranch(branchHashToDelete, namespace, labelList, options.DryRun)
+
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(output)
+			continue
+		}
+
+		err = deleteUnprotected(kubeClient, namespace, objects, options.Protect, options.DryRun)
 
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
+	}
 
-		fmt.Println(output)
+	if options.Expired {
+		return cleanExpired(kubeClient, namespace, labelList, options.Protect, options.DryRun)
 	}
+
+	return nil
 }
 
-func deploy(deployerSpec DeployerSpec, dryRun bool, verbose bool) {
+type DeployOptions struct {
+	DryRun            bool
+	Verbose           bool
+	Timeout           time.Duration
+	RollbackOnFailure bool
+	Prune             bool
+}
+
+func deploy(kubeClient KubeClient, deployerSpec DeployerSpec, options DeployOptions) error {
 	kubernetesDefinition, err := render(deployerSpec)
 
 	if err != nil {
-		log.Fatalf("error: %v", err)
+		return err
 	}
 
-	var kubeCtl = KubeClient{
-		Token:   deployerSpec.Cluster.Token,
-		Server:  deployerSpec.Cluster.Host,
-		Context: deployerSpec.Cluster.Context,
-		Verbose: verbose,
+	serverVersion, err := kubeClient.Version()
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Client Version: %s\nServer Version: %s\n\n", version(), serverVersion)
+
+	if options.Verbose {
+		color.Yellow(kubernetesDefinition)
 	}
 
-	kubeCtl.Version()
+	var snapshot *Snapshot
+
+	if options.RollbackOnFailure && !options.DryRun {
+		snapshot, err = TakeSnapshot(kubeClient, kubernetesDefinition, deployerSpec.Namespace)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	output, err := kubeClient.Apply(kubernetesDefinition, deployerSpec.Namespace, options.DryRun)
+
+	if output != "" {
+		color.Green(output)
+	}
+
+	if err == nil && !options.DryRun && options.Timeout > 0 {
+		err = WaitForRollout(kubeClient, kubernetesDefinition, deployerSpec.Namespace, options.Timeout)
+	}
+
+	if err != nil && snapshot != nil {
+		return rollbackAfterFailure(kubeClient, snapshot, deployerSpec.Namespace, err)
+	}
+
+	if err == nil && options.Prune {
+		output, err = prune(kubeClient, deployerSpec, kubernetesDefinition, options.DryRun)
+
+		if output != "" {
+			color.Green(output)
+		}
+	}
+
+	if err != nil || options.DryRun {
+		return err
+	}
+
+	err = SaveRelease(kubeClient, deployerSpec.Namespace, NewRelease(deployerSpec, kubernetesDefinition))
+
+	if err != nil {
+		return err
+	}
+
+	urls, err := IngressUrls(kubernetesDefinition)
+
+	for _, url := range urls {
+		fmt.Println(url)
+	}
 
-	kubeCtl.Apply(kubernetesDefinition, deployerSpec.Namespace, dryRun)
+	return err
 }
 
 func render(deployerSpec DeployerSpec) (string, error) {
-	objects, err := deployerSpec.ParseKubernetesYamlFiles()
+	var objects []map[string]interface{}
+	var renderContext RenderContext
+	var err error
+
+	renderContext.Partials, err = deployerSpec.LoadPartials()
 
 	if err != nil {
-		log.Fatal(err.Error())
+		return "", err
 	}
 
-	var renderContext RenderContext
-	err = renderContext.Build(deployerSpec, objects)
+	renderContext.Secrets, err = deployerSpec.ReadSecrets()
+
+	if err != nil {
+		return "", err
+	}
+
+	generatedObjects, nameSuffixes, err := deployerSpec.GenerateObjects()
+
+	if err != nil {
+		return "", err
+	}
+
+	renderContext.NameSuffixes = nameSuffixes
+	generatedObjects = append(SecretObjects(renderContext.Secrets), generatedObjects...)
+
+	if deployerSpec.Rendering == RENDERING_TEXT {
+		objects, err = renderContext.RenderTemplateFiles(deployerSpec, generatedObjects)
+	} else {
+		objects, err = deployerSpec.ParseKubernetesYamlFiles()
+		objects = append(objects, generatedObjects...)
+	}
+
+	if err == nil {
+		err = checkDuplicateObjects(objects)
+	}
+
+	if err == nil {
+		objects, err = deployerSpec.ApplyPatches(objects, &renderContext)
+	}
+
+	if err == nil {
+		err = renderContext.Build(deployerSpec, objects)
+	}
 
 	if err != nil {
-		log.Fatal(err.Error())
+		return "", err
 	}
 
 	injectContext := InjectContext{
 		Objects:    renderContext.Objects,
 		Env:        renderContext.Env,
 		Branch:     renderContext.Branch,
+		BranchName: deployerSpec.Branch,
 		Namespace:  renderContext.Namespace,
+		Project:    renderContext.Project,
 		TagVersion: renderContext.DeployerSpec.TagVersion,
+		Provenance: NewProvenance(deployerSpec),
+	}
+
+	if deployerSpec.Ttl > 0 {
+		injectContext.ExpiresAt = now().Add(deployerSpec.Ttl).UTC()
+	}
+
+	injectContext.IngressHost, err = deployerSpec.IngressHost(renderContext)
+
+	if err != nil {
+		return "", err
 	}
+
+	// Generated objects are marked before InjectMetadata makes their names
+	// env aware
+	generatedKeys := map[string]bool{}
+	for _, object := range generatedObjects {
+		generatedKeys[objectKey(object)] = true
+	}
+
+	generated := make([]bool, len(objects))
+	for i, object := range objects {
+		generated[i] = generatedKeys[objectKey(object)]
+	}
+
 	objects = InjectMetadata(injectContext, objects)
 
 	templates := make([]string, 0)
+	generatedTemplates := make([]string, 0)
 
-	for _, object := range objects {
+	for i, object := range objects {
 		template, err := yaml.Marshal(object)
 
 		if err != nil {
-			log.Fatalf("error: %v", err)
+			return "", err
+		}
+
+		if generated[i] {
+			generatedTemplates = append(generatedTemplates, string(template))
+		} else {
+			templates = append(templates, string(template))
+		}
+	}
+
+	// Generated objects and text rendered templates are complete, rendering
+	// them (again) would break literal braces in their content
+	if deployerSpec.Rendering == RENDERING_TEXT {
+		templates = append(templates, generatedTemplates...)
+	} else {
+		renderedTemplates, err := renderContext.Render(templates)
+
+		if err != nil {
+			return "", err
 		}
 
-		templates = append(templates, string(template))
+		templates = generatedTemplates
+
+		if renderedTemplates != "" {
+			templates = append([]string{renderedTemplates}, generatedTemplates...)
+		}
 	}
 
-	return renderContext.Render(templates)
+	return injectConfigChecksums(strings.Join(templates, "\n---\n"))
 }
 
 func version() string {
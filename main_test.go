@@ -0,0 +1,174 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testAppTemplate = `
+kind: Service
+apiVersion: v1
+metadata:
+  name: web
+spec:
+  ports:
+    - name: http
+      port: 80
+  selector:
+    app: web
+
+---
+
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+        - name: php
+          image: "{{ context.containers.php.name }}"
+`
+
+func writeTestProject(t *testing.T, files map[string]string) string {
+	projectDir, err := ioutil.TempDir("", "kube-deployer")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		filePath := filepath.Join(projectDir, name)
+
+		err = os.MkdirAll(filepath.Dir(filePath), 0755)
+
+		if err == nil {
+			err = ioutil.WriteFile(filePath, []byte(content), 0644)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return projectDir
+}
+
+func testDeployerSpec(projectDir string, branch string) DeployerSpec {
+	return DeployerSpec{
+		ProjectDir: projectDir,
+		TagVersion: "42",
+		Env:        branch,
+		Branch:     branch,
+		Namespace:  "staging",
+		Templates:  []string{"app.yml"},
+		Containers: []DeployerSpecContainer{
+			{
+				Id:    "php",
+				Image: "foo/bar",
+			},
+		},
+	}
+}
+
+func TestDeploy(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{"app.yml": testAppTemplate})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+	kubeClient := NewFakeKubeClient()
+
+	err := deploy(kubeClient, testDeployerSpec(projectDir, "feature-1"), DeployOptions{DryRun: true})
+
+	assert.Nil(err)
+	assert.Empty(kubeClient.Objects)
+
+	err = deploy(kubeClient, testDeployerSpec(projectDir, "feature-1"), DeployOptions{})
+
+	assert.Nil(err)
+	assert.Len(kubeClient.Objects, 3)
+
+	deployment := kubeClient.Object("staging", "Deployment", "feature-1-web")
+	assert.NotNil(deployment)
+
+	labels := fakeObjectLabels(deployment)
+	assert.Equal("feature-1", labels["env"])
+	assert.Equal(MD5("feature-1"), labels["branch_hash"])
+	assert.Equal("42", labels["version"])
+
+	container := deployment["spec"].(map[interface{}]interface{})["template"].(map[interface{}]interface{})["spec"].(map[interface{}]interface{})["containers"].([]interface{})[0]
+	assert.Equal("foo/bar:42", container.(map[interface{}]interface{})["image"])
+}
+
+func TestClean(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{"app.yml": testAppTemplate})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+	kubeClient := NewFakeKubeClient()
+
+	for _, branch := range []string{"master", "feature-1", "feature-2"} {
+		err := deploy(kubeClient, testDeployerSpec(projectDir, branch), DeployOptions{})
+		assert.Nil(err)
+	}
+
+	projectBranchHashes := map[string]string{
+		MD5("master"):    "master",
+		MD5("feature-2"): "feature-2",
+	}
+
+	err := clean(kubeClient, "staging", projectBranchHashes, map[string]string{}, CleanOptions{DryRun: true})
+
+	assert.Nil(err)
+	assert.Len(kubeClient.Objects, 9)
+
+	err = clean(kubeClient, "staging", projectBranchHashes, map[string]string{}, CleanOptions{})
+
+	assert.Nil(err)
+	assert.Len(kubeClient.Objects, 6)
+	assert.Nil(kubeClient.Object("staging", "Deployment", "feature-1-web"))
+	assert.NotNil(kubeClient.Object("staging", "Deployment", "feature-2-web"))
+	assert.NotNil(kubeClient.Object("staging", "Service", "master-web"))
+}
+
+func TestCleanScopedToProject(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{"app.yml": testAppTemplate})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+	kubeClient := NewFakeKubeClient()
+
+	deployments := map[string]string{
+		"master":    "project-a",
+		"feature-1": "project-a",
+		"feature-b": "project-b",
+	}
+
+	for branch, project := range deployments {
+		deployerSpec := testDeployerSpec(projectDir, branch)
+		deployerSpec.Project = project
+
+		err := deploy(kubeClient, deployerSpec, DeployOptions{})
+		assert.Nil(err)
+	}
+
+	assert.Equal("project-b", fakeObjectLabels(kubeClient.Object("staging", "Deployment", "feature-b-web"))["project"])
+	assert.NotNil(kubeClient.Object("staging", "Secret", "feature-b-project-b-release-42"))
+
+	err := clean(kubeClient, "staging", map[string]string{MD5("master"): "master"}, map[string]string{"project": "project-a"}, CleanOptions{})
+
+	assert.Nil(err)
+	assert.Nil(kubeClient.Object("staging", "Deployment", "feature-1-web"))
+	assert.NotNil(kubeClient.Object("staging", "Deployment", "master-web"))
+	assert.NotNil(kubeClient.Object("staging", "Deployment", "feature-b-web"))
+	assert.NotNil(kubeClient.Object("staging", "Service", "feature-b-web"))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+)
+
+/*
+ * Objects parsed from templates are nested map[interface{}]interface{} (yaml),
+ * objects fetched from the api are nested map[string]interface{} (json).
+ * The helpers below work with both.
+ */
+
+func NestedValue(object interface{}, fields ...string) interface{} {
+	value := object
+
+	for _, field := range fields {
+		switch typed := value.(type) {
+		case map[string]interface{}:
+			value = typed[field]
+		case map[interface{}]interface{}:
+			value = typed[field]
+		default:
+			return nil
+		}
+	}
+
+	return value
+}
+
+func NestedString(object interface{}, fields ...string) string {
+	value, _ := NestedValue(object, fields...).(string)
+
+	return value
+}
+
+func NestedInt(object interface{}, fields ...string) (int, bool) {
+	switch value := NestedValue(object, fields...).(type) {
+	case int:
+		return value, true
+	case int64:
+		return int(value), true
+	case float64:
+		return int(value), true
+	}
+
+	return 0, false
+}
+
+func NestedSlice(object interface{}, fields ...string) []interface{} {
+	value, _ := NestedValue(object, fields...).([]interface{})
+
+	return value
+}
+
+/*
+ * Converts all nested yaml maps into json style maps.
+ */
+func NormalizeObject(object map[string]interface{}) map[string]interface{} {
+	return normalizeValue(object).(map[string]interface{})
+}
+
+func normalizeValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			normalized[key] = normalizeValue(item)
+		}
+		return normalized
+	case map[interface{}]interface{}:
+		normalized := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			normalized[fmt.Sprint(key)] = normalizeValue(item)
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(typed))
+		for i, item := range typed {
+			normalized[i] = normalizeValue(item)
+		}
+		return normalized
+	}
+
+	return value
+}
+
+var SERVER_METADATA_FIELDS = []string{
+	"uid",
+	"resourceVersion",
+	"generation",
+	"creationTimestamp",
+	"deletionTimestamp",
+	"deletionGracePeriodSeconds",
+	"managedFields",
+	"selfLink",
+}
+
+var SERVER_ANNOTATIONS = []string{
+	"kubectl.kubernetes.io/last-applied-configuration",
+	"deployment.kubernetes.io/revision",
+}
+
+/*
+ * Removes status and the metadata the api server maintains itself, so a live
+ * object can be applied again. The given object is modified.
+ */
+func StripServerFields(object map[string]interface{}) map[string]interface{} {
+	delete(object, "status")
+
+	metadata, ok := object["metadata"].(map[string]interface{})
+
+	if !ok {
+		return object
+	}
+
+	for _, field := range SERVER_METADATA_FIELDS {
+		delete(metadata, field)
+	}
+
+	if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+		for _, annotation := range SERVER_ANNOTATIONS {
+			delete(annotations, annotation)
+		}
+
+		if len(annotations) == 0 {
+			delete(metadata, "annotations")
+		}
+	}
+
+	return object
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var partialReference = regexp.MustCompile(`\{\{~?>\s*([^\s}()~]+)`)
+
+/*
+ * Reads all files of the partials dir of the project. A partial is named by
+ * its path relative to the dir without extension, e.g. probes/java for
+ * probes/java.yml. All partial references of the templates and partials are
+ * checked, so a missing partial names the file using it.
+ */
+func (deployerSpec DeployerSpec) LoadPartials() (map[string]string, error) {
+	partials := map[string]string{}
+
+	if deployerSpec.PartialsDir != "" {
+		partialsDir := filepath.Join(deployerSpec.ProjectDir, deployerSpec.PartialsDir)
+
+		err := filepath.Walk(partialsDir, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+
+			content, err := ioutil.ReadFile(filePath)
+
+			if err != nil {
+				return err
+			}
+
+			name, err := filepath.Rel(partialsDir, filePath)
+
+			if err != nil {
+				return err
+			}
+
+			name = filepath.ToSlash(strings.TrimSuffix(name, filepath.Ext(name)))
+			partials[name] = string(content)
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot read partials dir %s: %v", partialsDir, err)
+		}
+	}
+
+	names := make([]string, 0, len(partials))
+	for name := range partials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err := checkPartialReferences("partial "+name, partials[name], partials)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	templates, err := deployerSpec.ReadTemplateFiles()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i, template := range templates {
+		err = checkPartialReferences("template "+deployerSpec.Templates[i], template, partials)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return partials, nil
+}
+
+func checkPartialReferences(source string, template string, partials map[string]string) error {
+	for _, match := range partialReference.FindAllStringSubmatch(template, -1) {
+		if _, ok := partials[match[1]]; !ok {
+			return fmt.Errorf("%s: partial %s not found", source, match[1])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const PATCH_TYPE_MERGE = "merge"
+const PATCH_TYPE_JSON = "json"
+
+/*
+ * A patch changes objects of the base templates for a target and
+ * optionally only for envs matching one of the given globs.
+ *
+ * Merge patches are yaml objects identified by kind and metadata.name. Maps
+ * are merged, null deletes a key. Lists whose items all have a name
+ * (containers, env, volumes, ports, ...) are merged by name, an item with
+ * "$patch: delete" removes the item. All other lists are replaced.
+ *
+ * JSON patches (RFC 6902) are a list of operations applied to the target
+ * object, written in yaml or json.
+ */
+type Patch struct {
+	File   string `yaml:"file"`
+	Type   string `yaml:"type"`
+	Target struct {
+		Kind string `yaml:"kind"`
+		Name string `yaml:"name"`
+	} `yaml:"target"`
+	Envs []string `yaml:"envs"`
+}
+
+type jsonPatchOperation struct {
+	Op    string      `yaml:"op"`
+	Path  string      `yaml:"path"`
+	From  string      `yaml:"from"`
+	Value interface{} `yaml:"value"`
+}
+
+/*
+ * Applies the patches of the target to the objects. In text rendering mode
+ * the patch files are rendered like the templates.
+ */
+func (deployerSpec DeployerSpec) ApplyPatches(objects []map[string]interface{}, renderContext *RenderContext) ([]map[string]interface{}, error) {
+	for _, patch := range deployerSpec.Patches {
+		if !patch.appliesTo(deployerSpec.Env) {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(deployerSpec.ProjectDir, patch.File))
+
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read file %s", patch.File)
+		}
+
+		if deployerSpec.Rendering == RENDERING_TEXT {
+			rendered, err := renderContext.Render([]string{string(content)})
+
+			if err != nil {
+				return nil, fmt.Errorf("patch %s: %v", patch.File, err)
+			}
+
+			content = []byte(rendered)
+		}
+
+		switch patch.Type {
+		case "", PATCH_TYPE_MERGE:
+			err = applyMergePatches(objects, string(content))
+		case PATCH_TYPE_JSON:
+			err = applyJsonPatch(objects, patch.Target.Kind, patch.Target.Name, content)
+		default:
+			err = fmt.Errorf("unknown type %s, use %s or %s", patch.Type, PATCH_TYPE_MERGE, PATCH_TYPE_JSON)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("patch %s: %v", patch.File, err)
+		}
+	}
+
+	return objects, nil
+}
+
+func (patch Patch) appliesTo(env string) bool {
+	if len(patch.Envs) == 0 {
+		return true
+	}
+
+	for _, envPattern := range patch.Envs {
+		if matched, _ := path.Match(envPattern, env); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func findPatchTarget(objects []map[string]interface{}, kind string, name string) (map[string]interface{}, error) {
+	for _, object := range objects {
+		if NestedString(object, "kind") == kind && NestedString(object, "metadata", "name") == name {
+			return object, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s/%s not found", kind, name)
+}
+
+/*
+ * Lists of these fields are merged by the key of their items, all other lists
+ * are replaced.
+ */
+var mergePatchKeys = map[string]string{
+	"containers":     "name",
+	"initContainers": "name",
+	"env":            "name",
+	"volumes":        "name",
+	"volumeMounts":   "mountPath",
+	"ports":          "containerPort",
+}
+
+func applyMergePatches(objects []map[string]interface{}, content string) error {
+	patches, err := UnmarshalYaml(content)
+
+	if err != nil {
+		return err
+	}
+
+	for _, patch := range patches {
+		object, err := findPatchTarget(objects, NestedString(patch, "kind"), NestedString(patch, "metadata", "name"))
+
+		if err != nil {
+			return err
+		}
+
+		for key, value := range patch {
+			object[key], err = mergePatchValue(key, object[key], value)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func mergePatchValue(field string, value interface{}, patch interface{}) (interface{}, error) {
+	switch typedPatch := patch.(type) {
+	case map[interface{}]interface{}:
+		if directive, ok := typedPatch["$patch"]; ok {
+			return nil, fmt.Errorf("%s: unsupported $patch: %v", field, directive)
+		}
+
+		typedValue, ok := value.(map[interface{}]interface{})
+
+		if !ok {
+			typedValue = map[interface{}]interface{}{}
+		}
+
+		for key, patchValue := range typedPatch {
+			if patchValue == nil {
+				delete(typedValue, key)
+				continue
+			}
+
+			merged, err := mergePatchValue(fmt.Sprint(key), typedValue[key], patchValue)
+
+			if err != nil {
+				return nil, err
+			}
+
+			typedValue[key] = merged
+		}
+
+		return typedValue, nil
+	case []interface{}:
+		mergeKey := mergePatchKeys[field]
+		typedValue, ok := value.([]interface{})
+
+		if !ok && value != nil || mergeKey == "" || !keyedItems(typedValue, mergeKey) || !keyedItems(typedPatch, mergeKey) {
+			return patch, checkPatchDirectives(field, typedPatch)
+		}
+
+		merged := typedValue
+
+		for _, patchItem := range typedPatch {
+			key := fmt.Sprint(NestedValue(patchItem, mergeKey))
+			index := -1
+
+			for i, item := range merged {
+				if fmt.Sprint(NestedValue(item, mergeKey)) == key {
+					index = i
+				}
+			}
+
+			directive, hasDirective := patchItem.(map[interface{}]interface{})["$patch"]
+
+			switch {
+			case hasDirective && directive != "delete":
+				return nil, fmt.Errorf("%s: unsupported $patch: %v", field, directive)
+			case hasDirective:
+				if index >= 0 {
+					merged = append(merged[:index:index], merged[index+1:]...)
+				}
+			case index >= 0:
+				item, err := mergePatchValue(field, merged[index], patchItem)
+
+				if err != nil {
+					return nil, err
+				}
+
+				merged[index] = item
+			default:
+				merged = append(merged, patchItem)
+			}
+		}
+
+		return merged, nil
+	}
+
+	return patch, nil
+}
+
+func keyedItems(items []interface{}, mergeKey string) bool {
+	for _, item := range items {
+		if _, ok := item.(map[interface{}]interface{}); !ok || NestedValue(item, mergeKey) == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+ * Items of replaced lists are copied as they are, directives in them would
+ * end up in the object.
+ */
+func checkPatchDirectives(field string, items []interface{}) error {
+	for _, item := range items {
+		if directive := NestedValue(item, "$patch"); directive != nil {
+			return fmt.Errorf("%s: $patch: %v needs a list merged by key", field, directive)
+		}
+	}
+
+	return nil
+}
+
+func applyJsonPatch(objects []map[string]interface{}, kind string, name string, content []byte) error {
+	var operations []jsonPatchOperation
+	err := yaml.Unmarshal(content, &operations)
+
+	if err != nil {
+		return err
+	}
+
+	object, err := findPatchTarget(objects, kind, name)
+
+	if err != nil {
+		return err
+	}
+
+	// The root is wrapped, so operations can replace top level fields
+	root := map[interface{}]interface{}{}
+	for key, value := range object {
+		root[key] = value
+	}
+
+	for _, operation := range operations {
+		err = applyJsonPatchOperation(root, operation)
+
+		if err != nil {
+			return fmt.Errorf("%s %s: %v", operation.Op, operation.Path, err)
+		}
+	}
+
+	for key := range object {
+		delete(object, key)
+	}
+
+	for key, value := range root {
+		object[fmt.Sprint(key)] = value
+	}
+
+	return nil
+}
+
+func applyJsonPatchOperation(root interface{}, operation jsonPatchOperation) error {
+	switch operation.Op {
+	case "add":
+		return jsonPointerSet(root, operation.Path, operation.Value, true)
+	case "replace":
+		return jsonPointerSet(root, operation.Path, operation.Value, false)
+	case "remove":
+		_, err := jsonPointerRemove(root, operation.Path)
+		return err
+	case "move":
+		value, err := jsonPointerRemove(root, operation.From)
+
+		if err != nil {
+			return err
+		}
+
+		return jsonPointerSet(root, operation.Path, value, true)
+	case "copy":
+		value, err := jsonPointerGet(root, operation.From)
+
+		if err != nil {
+			return err
+		}
+
+		return jsonPointerSet(root, operation.Path, copyValue(value), true)
+	case "test":
+		value, err := jsonPointerGet(root, operation.Path)
+
+		if err != nil {
+			return err
+		}
+
+		if !reflect.DeepEqual(normalizeValue(value), normalizeValue(operation.Value)) {
+			return errors.New("test failed")
+		}
+
+		return nil
+	}
+
+	return errors.New("unknown operation")
+}
+
+func parseJsonPointer(pointer string) ([]string, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid path %s", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+/*
+ * Returns the container of the last token of the pointer.
+ */
+func jsonPointerParent(root interface{}, pointer string) (interface{}, string, []string, error) {
+	tokens, err := parseJsonPointer(pointer)
+
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	parent, err := jsonPointerWalk(root, tokens[:len(tokens)-1])
+
+	return parent, tokens[len(tokens)-1], tokens[:len(tokens)-1], err
+}
+
+func jsonPointerWalk(value interface{}, tokens []string) (interface{}, error) {
+	for _, token := range tokens {
+		switch typed := value.(type) {
+		case map[interface{}]interface{}:
+			child, ok := typed[token]
+
+			if !ok {
+				return nil, fmt.Errorf("%s not found", token)
+			}
+
+			value = child
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+
+			if err != nil || index < 0 || index >= len(typed) {
+				return nil, fmt.Errorf("invalid index %s", token)
+			}
+
+			value = typed[index]
+		default:
+			return nil, fmt.Errorf("%s not found", token)
+		}
+	}
+
+	return value, nil
+}
+
+func jsonPointerGet(root interface{}, pointer string) (interface{}, error) {
+	tokens, err := parseJsonPointer(pointer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return jsonPointerWalk(root, tokens)
+}
+
+func jsonPointerSet(root interface{}, pointer string, value interface{}, insert bool) error {
+	parent, token, parentTokens, err := jsonPointerParent(root, pointer)
+
+	if err != nil {
+		return err
+	}
+
+	switch typed := parent.(type) {
+	case map[interface{}]interface{}:
+		if _, ok := typed[token]; !ok && !insert {
+			return fmt.Errorf("%s not found", token)
+		}
+
+		typed[token] = value
+
+		return nil
+	case []interface{}:
+		index := len(typed)
+
+		if token != "-" || !insert {
+			index, err = strconv.Atoi(token)
+
+			if err != nil || index < 0 || index > len(typed) || (!insert && index == len(typed)) {
+				return fmt.Errorf("invalid index %s", token)
+			}
+		}
+
+		if !insert {
+			typed[index] = value
+			return nil
+		}
+
+		// Inserting changes the length, the list has to be stored again
+		list := append(typed[:index:index], append([]interface{}{value}, typed[index:]...)...)
+
+		return jsonPointerReplaceList(root, parentTokens, list)
+	}
+
+	return fmt.Errorf("%s not found", token)
+}
+
+func jsonPointerRemove(root interface{}, pointer string) (interface{}, error) {
+	parent, token, parentTokens, err := jsonPointerParent(root, pointer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch typed := parent.(type) {
+	case map[interface{}]interface{}:
+		value, ok := typed[token]
+
+		if !ok {
+			return nil, fmt.Errorf("%s not found", token)
+		}
+
+		delete(typed, token)
+
+		return value, nil
+	case []interface{}:
+		index, err := strconv.Atoi(token)
+
+		if err != nil || index < 0 || index >= len(typed) {
+			return nil, fmt.Errorf("invalid index %s", token)
+		}
+
+		value := typed[index]
+
+		return value, jsonPointerReplaceList(root, parentTokens, append(typed[:index:index], typed[index+1:]...))
+	}
+
+	return nil, fmt.Errorf("%s not found", token)
+}
+
+func jsonPointerReplaceList(root interface{}, tokens []string, list []interface{}) error {
+	parent, err := jsonPointerWalk(root, tokens[:len(tokens)-1])
+
+	if err != nil {
+		return err
+	}
+
+	token := tokens[len(tokens)-1]
+
+	switch typed := parent.(type) {
+	case map[interface{}]interface{}:
+		typed[token] = list
+	case []interface{}:
+		index, _ := strconv.Atoi(token)
+		typed[index] = list
+	}
+
+	return nil
+}
+
+func copyValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[interface{}]interface{}:
+		copied := make(map[interface{}]interface{}, len(typed))
+		for key, item := range typed {
+			copied[key] = copyValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(typed))
+		for i, item := range typed {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	}
+
+	return value
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestApplyMergePatch(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		"app.yml": testAppTemplate,
+		"patches/production.yml": `
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app: null
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+        - name: php
+          resources:
+            limits:
+              memory: 512Mi
+        - name: nginx
+          image: nginx
+`,
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+
+	deployerSpec := testDeployerSpec(projectDir, "production")
+	deployerSpec.Patches = []Patch{{File: "patches/production.yml", Envs: []string{"prod*"}}}
+
+	definition, err := render(deployerSpec)
+
+	assert.Nil(err)
+	assert.Contains(definition, "replicas: 3")
+	assert.Contains(definition, "memory: 512Mi")
+	assert.Contains(definition, "image: 'foo/bar:42'")
+	assert.Contains(definition, "image: nginx")
+	assert.NotContains(definition, "app: web\n    branch: production")
+
+	deployerSpec = testDeployerSpec(projectDir, "feature-1")
+	deployerSpec.Patches = []Patch{{File: "patches/production.yml", Envs: []string{"prod*"}}}
+
+	definition, err = render(deployerSpec)
+
+	assert.Nil(err)
+	assert.NotContains(definition, "replicas: 3")
+}
+
+func TestMergePatchValue(t *testing.T) {
+	assert := assert.New(t)
+
+	value := []interface{}{
+		map[interface{}]interface{}{"name": "a", "value": "1"},
+		map[interface{}]interface{}{"name": "b", "value": "2"},
+	}
+
+	merged, err := mergePatchValue("env", value, []interface{}{
+		map[interface{}]interface{}{"name": "a", "$patch": "delete"},
+		map[interface{}]interface{}{"name": "b", "value": "3"},
+	})
+
+	assert.Nil(err)
+	assert.Equal([]interface{}{map[interface{}]interface{}{"name": "b", "value": "3"}}, merged)
+
+	merged, err = mergePatchValue("args", []interface{}{"a", "b"}, []interface{}{"c"})
+	assert.Nil(err)
+	assert.Equal([]interface{}{"c"}, merged)
+
+	// the same volume is mounted at several paths
+	merged, err = mergePatchValue("volumeMounts", []interface{}{
+		map[interface{}]interface{}{"name": "data", "mountPath": "/a"},
+		map[interface{}]interface{}{"name": "data", "mountPath": "/b"},
+	}, []interface{}{
+		map[interface{}]interface{}{"name": "data", "mountPath": "/b", "readOnly": true},
+		map[interface{}]interface{}{"name": "data", "mountPath": "/c"},
+	})
+
+	assert.Nil(err)
+	assert.Equal([]interface{}{
+		map[interface{}]interface{}{"name": "data", "mountPath": "/a"},
+		map[interface{}]interface{}{"name": "data", "mountPath": "/b", "readOnly": true},
+		map[interface{}]interface{}{"name": "data", "mountPath": "/c"},
+	}, merged)
+
+	merged, err = mergePatchValue("ports", []interface{}{
+		map[interface{}]interface{}{"name": "http", "containerPort": 80},
+	}, []interface{}{
+		map[interface{}]interface{}{"containerPort": 80, "$patch": "delete"},
+		map[interface{}]interface{}{"containerPort": 8080},
+	})
+
+	assert.Nil(err)
+	assert.Equal([]interface{}{map[interface{}]interface{}{"containerPort": 8080}}, merged)
+
+	// lists of other fields are replaced, even if their items have a name
+	merged, err = mergePatchValue("tolerations", []interface{}{
+		map[interface{}]interface{}{"name": "a"},
+	}, []interface{}{
+		map[interface{}]interface{}{"name": "b"},
+	})
+
+	assert.Nil(err)
+	assert.Equal([]interface{}{map[interface{}]interface{}{"name": "b"}}, merged)
+}
+
+func TestMergePatchDirectiveErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	value := []interface{}{map[interface{}]interface{}{"name": "a"}}
+
+	_, err := mergePatchValue("env", value, []interface{}{
+		map[interface{}]interface{}{"name": "a", "$patch": "replace"},
+	})
+	assert.EqualError(err, "env: unsupported $patch: replace")
+
+	_, err = mergePatchValue("spec", map[interface{}]interface{}{}, map[interface{}]interface{}{"$patch": "delete"})
+	assert.EqualError(err, "spec: unsupported $patch: delete")
+
+	_, err = mergePatchValue("args", []interface{}{"a"}, []interface{}{
+		map[interface{}]interface{}{"name": "a", "$patch": "delete"},
+	})
+	assert.EqualError(err, "args: $patch: delete needs a list merged by key")
+}
+
+func TestApplyJsonPatch(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		"app.yml": testAppTemplate,
+		"patches/web.json": `[
+  {"op": "test", "path": "/spec/template/spec/containers/0/name", "value": "php"},
+  {"op": "add", "path": "/spec/template/spec/containers/0/args", "value": ["--verbose"]},
+  {"op": "add", "path": "/spec/template/spec/containers/-", "value": {"name": "nginx", "image": "nginx"}},
+  {"op": "replace", "path": "/metadata/labels/app", "value": "web-app"},
+  {"op": "copy", "from": "/metadata/labels", "path": "/metadata/annotations"},
+  {"op": "remove", "path": "/spec/template/metadata"}
+]`,
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+
+	deployerSpec := testDeployerSpec(projectDir, "feature-1")
+	patch := Patch{File: "patches/web.json", Type: PATCH_TYPE_JSON}
+	patch.Target.Kind = "Deployment"
+	patch.Target.Name = "web"
+	deployerSpec.Patches = []Patch{patch}
+
+	definition, err := render(deployerSpec)
+
+	assert.Nil(err)
+	assert.Contains(definition, "- --verbose")
+	assert.Contains(definition, "image: nginx")
+	assert.Contains(definition, "app: web-app")
+
+	patch.Target.Name = "api"
+	deployerSpec.Patches = []Patch{patch}
+
+	_, err = render(deployerSpec)
+
+	assert.EqualError(err, "patch patches/web.json: Deployment/api not found")
+}
+
+func TestApplyJsonPatchOperationErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	root := map[interface{}]interface{}{"spec": map[interface{}]interface{}{"replicas": 1}}
+
+	assert.EqualError(applyJsonPatchOperation(root, jsonPatchOperation{Op: "test", Path: "/spec/replicas", Value: 2}), "test failed")
+	assert.EqualError(applyJsonPatchOperation(root, jsonPatchOperation{Op: "replace", Path: "/spec/paused", Value: true}), "paused not found")
+	assert.EqualError(applyJsonPatchOperation(root, jsonPatchOperation{Op: "add", Path: "spec"}), "invalid path spec")
+	assert.Nil(applyJsonPatchOperation(root, jsonPatchOperation{Op: "move", From: "/spec/replicas", Path: "/replicas"}))
+	assert.Equal(1, root["replicas"])
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const PROTECTED_ANNOTATION = "kube-deployer/protected"
+const BRANCH_ANNOTATION = "kube-deployer/branch"
+
+/*
+ * Protection rules are globs (static-*, release/*) or regular expressions
+ * enclosed in slashes (/^hotfix-[0-9]+$/). They are matched against the env,
+ * the branch label and the untruncated branch of an object.
+ */
+type ProtectRules struct {
+	globs   []string
+	regexps []*regexp.Regexp
+}
+
+func NewProtectRules(patterns []string) (ProtectRules, error) {
+	var rules ProtectRules
+
+	for _, pattern := range patterns {
+		if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			rp, err := regexp.Compile(pattern[1 : len(pattern)-1])
+
+			if err != nil {
+				return rules, fmt.Errorf("invalid protect rule %s: %v", pattern, err)
+			}
+
+			rules.regexps = append(rules.regexps, rp)
+			continue
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return rules, fmt.Errorf("invalid protect rule %s: %v", pattern, err)
+		}
+
+		rules.globs = append(rules.globs, pattern)
+	}
+
+	return rules, nil
+}
+
+/*
+ * Returns why the object is protected, or an empty string if it is not.
+ */
+func (rules ProtectRules) Reason(object map[string]interface{}) string {
+	if NestedString(object, "metadata", "annotations", PROTECTED_ANNOTATION) == "true" {
+		return PROTECTED_ANNOTATION + " annotation"
+	}
+
+	values := map[string]string{
+		"env":    NestedString(object, "metadata", "labels", "env"),
+		"branch": NestedString(object, "metadata", "labels", "branch"),
+	}
+
+	if branch := NestedString(object, "metadata", "annotations", BRANCH_ANNOTATION); branch != "" {
+		values["branch"] = branch
+	}
+
+	for _, name := range []string{"env", "branch"} {
+		value := values[name]
+
+		if value == "" {
+			continue
+		}
+
+		for _, glob := range rules.globs {
+			if matched, _ := path.Match(glob, value); matched {
+				return fmt.Sprintf("%s %s matches %s", name, value, glob)
+			}
+		}
+
+		for _, rp := range rules.regexps {
+			if rp.MatchString(value) {
+				return fmt.Sprintf("%s %s matches /%s/", name, value, rp.String())
+			}
+		}
+	}
+
+	return ""
+}
+
+func (rules ProtectRules) Any(objects []map[string]interface{}) bool {
+	for _, object := range objects {
+		if rules.Reason(object) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Deletes the objects one by one and lists the protected ones instead of
+ * deleting them.
+ */
+func deleteUnprotected(kubeClient KubeClient, namespace string, objects []map[string]interface{}, protect ProtectRules, dryRun bool) error {
+	for _, object := range objects {
+		kind := NestedString(object, "kind")
+		name := NestedString(object, "metadata", "name")
+
+		if reason := protect.Reason(object); reason != "" {
+			fmt.Printf("%s \"%s\" protected (%s)\n", strings.ToLower(kind), name, reason)
+			continue
+		}
+
+		output, err := kubeClient.Delete(NestedString(object, "apiVersion"), kind, namespace, name, dryRun)
+
+		if err != nil && !IsNotFound(err) {
+			return err
+		}
+
+		if output != "" {
+			fmt.Println(output)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestProtectRules(t *testing.T) {
+	assert := assert.New(t)
+
+	rules, err := NewProtectRules([]string{"master", "release/*", "static-*", "/^hotfix-[0-9]+$/"})
+	assert.Nil(err)
+
+	object := func(env string, branch string, annotations map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels":      map[string]interface{}{"env": env, "branch": MakeUrlSlug(branch, DNS_MAX_LENGTH)},
+				"annotations": annotations,
+			},
+		}
+	}
+
+	assert.Equal("env master matches master", rules.Reason(object("master", "master", nil)))
+	assert.Equal("env static-1 matches static-*", rules.Reason(object("static-1", "master", nil)))
+	assert.Equal("branch release/1.0 matches release/*", rules.Reason(object("release-1-0", "release/1.0", map[string]interface{}{BRANCH_ANNOTATION: "release/1.0"})))
+	assert.Equal("env hotfix-12 matches /^hotfix-[0-9]+$/", rules.Reason(object("hotfix-12", "hotfix-12", nil)))
+	assert.Equal("kube-deployer/protected annotation", rules.Reason(object("feature-1", "feature-1", map[string]interface{}{PROTECTED_ANNOTATION: "true"})))
+	assert.Equal("", rules.Reason(object("feature-1", "feature-1", nil)))
+	assert.Equal("", rules.Reason(object("hotfix-x", "hotfix-x", nil)))
+
+	_, err = NewProtectRules([]string{"/[/"})
+	assert.NotNil(err)
+
+	_, err = NewProtectRules([]string{"static-["})
+	assert.NotNil(err)
+}
+
+func TestCleanProtected(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{"app.yml": testAppTemplate})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+	kubeClient := NewFakeKubeClient()
+
+	for _, branch := range []string{"master", "feature-1", "release/1.0"} {
+		err := deploy(kubeClient, testDeployerSpec(projectDir, branch), DeployOptions{})
+		assert.Nil(err)
+	}
+
+	staticSpec := testDeployerSpec(projectDir, "master")
+	staticSpec.Env = "static-1"
+
+	err := deploy(kubeClient, staticSpec, DeployOptions{})
+	assert.Nil(err)
+
+	// feature-1 keeps its service by annotation
+	service := kubeClient.Object("staging", "Service", "feature-1-web")
+	service["metadata"].(map[interface{}]interface{})["annotations"].(map[interface{}]interface{})[PROTECTED_ANNOTATION] = "true"
+
+	rules, err := NewProtectRules([]string{"release/*", "static-*"})
+	assert.Nil(err)
+
+	// ls-remote returned no branches at all
+	err = clean(kubeClient, "staging", map[string]string{}, map[string]string{}, CleanOptions{Protect: rules})
+
+	assert.Nil(err)
+	assert.Nil(kubeClient.Object("staging", "Deployment", "master-web"))
+	assert.Nil(kubeClient.Object("staging", "Deployment", "feature-1-web"))
+	assert.NotNil(kubeClient.Object("staging", "Service", "feature-1-web"))
+	assert.NotNil(kubeClient.Object("staging", "Deployment", "static-1-web"))
+	assert.NotNil(kubeClient.Object("staging", "Secret", "static-1-release-42"))
+	assert.NotNil(kubeClient.Object("staging", "Deployment", "release10-web"))
+	assert.NotNil(kubeClient.Object("staging", "Secret", "release10-release-42"))
+}
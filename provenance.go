@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const COMMIT_ANNOTATION = "kube-deployer/commit"
+const DEPLOYED_AT_ANNOTATION = "kube-deployer/deployed-at"
+const DEPLOYED_BY_ANNOTATION = "kube-deployer/deployed-by"
+const CI_JOB_ANNOTATION = "kube-deployer/ci-job"
+const DEPLOYER_VERSION_ANNOTATION = "kube-deployer/deployer-version"
+
+/*
+ * Annotations which differ on every deploy even if nothing else changed.
+ */
+var VOLATILE_ANNOTATIONS = []string{
+	DEPLOYED_AT_ANNOTATION,
+	DEPLOYED_BY_ANNOTATION,
+	CI_JOB_ANNOTATION,
+	EXPIRES_AT_ANNOTATION,
+}
+
+/*
+ * Where the objects of a deploy come from. Written as annotations on every
+ * object, empty values are left out.
+ */
+type Provenance struct {
+	Commit          string
+	DeployedAt      time.Time
+	DeployedBy      string
+	CiJob           string
+	DeployerVersion string
+}
+
+func NewProvenance(deployerSpec DeployerSpec) Provenance {
+	return Provenance{
+		Commit:          gitCommit(deployerSpec.ProjectDir),
+		DeployedAt:      now().UTC(),
+		DeployedBy:      firstEnv("GITLAB_USER_LOGIN", "GITHUB_ACTOR", "BUILD_USER_ID", "USER"),
+		CiJob:           ciJob(),
+		DeployerVersion: version(),
+	}
+}
+
+func (provenance Provenance) Annotations() map[string]string {
+	annotations := map[string]string{
+		COMMIT_ANNOTATION:           provenance.Commit,
+		DEPLOYED_BY_ANNOTATION:      provenance.DeployedBy,
+		CI_JOB_ANNOTATION:           provenance.CiJob,
+		DEPLOYER_VERSION_ANNOTATION: provenance.DeployerVersion,
+	}
+
+	if !provenance.DeployedAt.IsZero() {
+		annotations[DEPLOYED_AT_ANNOTATION] = provenance.DeployedAt.Format(time.RFC3339)
+	}
+
+	for name, value := range annotations {
+		if value == "" {
+			delete(annotations, name)
+		}
+	}
+
+	return annotations
+}
+
+/*
+ * The commit is taken from the CI if it provides one, otherwise from the
+ * checkout in the project dir.
+ */
+func gitCommit(projectDir string) string {
+	if commit := firstEnv("CI_COMMIT_SHA", "GITHUB_SHA", "GIT_COMMIT"); commit != "" {
+		return commit
+	}
+
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = projectDir
+
+	output, err := cmd.Output()
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(output))
+}
+
+func ciJob() string {
+	if os.Getenv("GITHUB_RUN_ID") != "" {
+		return os.Getenv("GITHUB_SERVER_URL") + "/" + os.Getenv("GITHUB_REPOSITORY") + "/actions/runs/" + os.Getenv("GITHUB_RUN_ID")
+	}
+
+	return firstEnv("CI_JOB_URL", "BUILD_URL")
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
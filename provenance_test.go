@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+/*
+ * Sets the env vars and returns a function restoring the previous values,
+ * vars which were not set before are unset again.
+ */
+func setTestEnv(env map[string]string) func() {
+	previous := map[string]*string{}
+
+	for name, value := range env {
+		if previousValue, ok := os.LookupEnv(name); ok {
+			previous[name] = &previousValue
+		} else {
+			previous[name] = nil
+		}
+
+		os.Setenv(name, value)
+	}
+
+	return func() {
+		for name, value := range previous {
+			if value == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *value)
+			}
+		}
+	}
+}
+
+func TestSetTestEnv(t *testing.T) {
+	os.Unsetenv("KUBE_DEPLOYER_TEST_UNSET")
+
+	restore := setTestEnv(map[string]string{"KUBE_DEPLOYER_TEST_UNSET": "1"})
+	assert.Equal(t, "1", os.Getenv("KUBE_DEPLOYER_TEST_UNSET"))
+
+	restore()
+
+	_, ok := os.LookupEnv("KUBE_DEPLOYER_TEST_UNSET")
+	assert.False(t, ok)
+}
+
+func TestProvenance(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{"app.yml": testAppTemplate})
+	defer os.RemoveAll(projectDir)
+
+	defer setTestEnv(map[string]string{
+		"CI_COMMIT_SHA":     "0d1f2e3c4b5a69788796a5b4c3d2e1f0a9b8c7d6",
+		"GITLAB_USER_LOGIN": "jane",
+		"CI_JOB_URL":        "https://gitlab.example.com/foo/bar/-/jobs/42",
+		"GITHUB_RUN_ID":     "",
+	})()
+
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+	assert := assert.New(t)
+	kubeClient := NewFakeKubeClient()
+
+	err := deploy(kubeClient, testDeployerSpec(projectDir, "feature/login"), DeployOptions{})
+	assert.Nil(err)
+
+	deployment := kubeClient.Object("staging", "Deployment", "featurelogin-web")
+
+	assert.Equal("0d1f2e3c4b5a69788796a5b4c3d2e1f0a9b8c7d6", NestedString(deployment, "metadata", "annotations", COMMIT_ANNOTATION))
+	assert.Equal("feature/login", NestedString(deployment, "metadata", "annotations", BRANCH_ANNOTATION))
+	assert.Equal("2018-03-01T12:00:00Z", NestedString(deployment, "metadata", "annotations", DEPLOYED_AT_ANNOTATION))
+	assert.Equal("jane", NestedString(deployment, "metadata", "annotations", DEPLOYED_BY_ANNOTATION))
+	assert.Equal("https://gitlab.example.com/foo/bar/-/jobs/42", NestedString(deployment, "metadata", "annotations", CI_JOB_ANNOTATION))
+	assert.Equal("dev", NestedString(deployment, "metadata", "annotations", DEPLOYER_VERSION_ANNOTATION))
+
+	// a later render by someone else is not a change
+	now = func() time.Time { return time.Date(2018, 3, 2, 12, 0, 0, 0, time.UTC) }
+	defer setTestEnv(map[string]string{"GITLAB_USER_LOGIN": "john"})()
+
+	_, changed, err := diff(kubeClient, testDeployerSpec(projectDir, "feature/login"))
+
+	assert.Nil(err)
+	assert.False(changed)
+}
+
+func TestProvenanceAnnotationsOmitEmptyValues(t *testing.T) {
+	annotations := Provenance{DeployerVersion: "1.2.0"}.Annotations()
+
+	assert.Equal(t, map[string]string{DEPLOYER_VERSION_ANNOTATION: "1.2.0"}, annotations)
+}
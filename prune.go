@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+)
+
+/*
+ * Deletes the objects of the env and branch which are not part of the
+ * definition anymore, e.g. because their template was removed.
+ */
+func prune(kubeClient KubeClient, deployerSpec DeployerSpec, definition string, dryRun bool) (string, error) {
+	objects, err := UnmarshalYaml(definition)
+
+	if err != nil {
+		return "", err
+	}
+
+	labels := map[string]string{
+		"env":         MakeUrlSlug(deployerSpec.Env, DNS_MAX_LENGTH),
+		"branch_hash": MD5(MakeUrlSlug(deployerSpec.Branch, DNS_MAX_LENGTH)),
+	}
+
+	if deployerSpec.Project != "" {
+		labels["project"] = MakeUrlSlug(deployerSpec.Project, DNS_MAX_LENGTH)
+	}
+
+	selector := LabelSelector(labels)
+
+	candidates, err := kubeClient.ListObjects(deployerSpec.Namespace, selector)
+
+	if err != nil {
+		return "", err
+	}
+
+	renderedKeys := make([]string, 0)
+	renderedKinds := make([]string, 0)
+
+	for _, object := range objects {
+		apiVersion := NestedString(object, "apiVersion")
+		kind := NestedString(object, "kind")
+
+		renderedKeys = append(renderedKeys, kind+"/"+NestedString(object, "metadata", "name"))
+
+		// Kinds clean does not look at are listed separately
+		if Contains(renderedKinds, apiVersion+"/"+kind) {
+			continue
+		}
+
+		renderedKinds = append(renderedKinds, apiVersion+"/"+kind)
+
+		items, err := kubeClient.List(apiVersion, kind, deployerSpec.Namespace, selector)
+
+		if err != nil {
+			return "", err
+		}
+
+		candidates = append(candidates, items...)
+	}
+
+	output := make([]string, 0)
+	prunedKeys := make([]string, 0)
+
+	for _, object := range candidates {
+		key := NestedString(object, "kind") + "/" + NestedString(object, "metadata", "name")
+
+		if Contains(renderedKeys, key) || Contains(prunedKeys, key) || !isPrunable(object) {
+			continue
+		}
+
+		prunedKeys = append(prunedKeys, key)
+
+		deleteOutput, err := kubeClient.Delete(
+			NestedString(object, "apiVersion"),
+			NestedString(object, "kind"),
+			deployerSpec.Namespace,
+			NestedString(object, "metadata", "name"),
+			dryRun,
+		)
+
+		if err != nil && !IsNotFound(err) {
+			return strings.Join(output, "\n"), err
+		}
+
+		if deleteOutput != "" {
+			output = append(output, deleteOutput)
+		}
+	}
+
+	return strings.Join(output, "\n"), nil
+}
+
+/*
+ * Release records and objects managed by other objects are never pruned.
+ */
+func isPrunable(object map[string]interface{}) bool {
+	if NestedString(object, "metadata", "labels", RELEASE_LABEL) == RELEASE_LABEL_VALUE {
+		return false
+	}
+
+	return len(NestedSlice(object, "metadata", "ownerReferences")) == 0
+}
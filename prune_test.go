@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDeployPrune(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		"app.yml":    testAppTemplate,
+		"config.yml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\ndata:\n  foo: bar\n",
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+	kubeClient := NewFakeKubeClient()
+
+	for _, branch := range []string{"master", "feature-1"} {
+		deployerSpec := testDeployerSpec(projectDir, branch)
+		deployerSpec.Templates = []string{"app.yml", "config.yml"}
+
+		err := deploy(kubeClient, deployerSpec, DeployOptions{})
+		assert.Nil(err)
+	}
+
+	deployerSpec := testDeployerSpec(projectDir, "master")
+	definition, err := render(deployerSpec)
+	assert.Nil(err)
+
+	output, err := prune(kubeClient, deployerSpec, definition, true)
+
+	assert.Nil(err)
+	assert.Equal("configmap \"master-config\" deleted (dry run)", output)
+	assert.NotNil(kubeClient.Object("staging", "ConfigMap", "master-config"))
+
+	err = deploy(kubeClient, deployerSpec, DeployOptions{Prune: true})
+
+	assert.Nil(err)
+	assert.Nil(kubeClient.Object("staging", "ConfigMap", "master-config"))
+	assert.NotNil(kubeClient.Object("staging", "ConfigMap", "feature-1-config"))
+	assert.NotNil(kubeClient.Object("staging", "Deployment", "master-web"))
+	assert.NotNil(kubeClient.Object("staging", "Secret", "master-release-42"))
+}
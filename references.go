@@ -0,0 +1,142 @@
+package main
+
+/*
+ * A name of another object stored under key in parent.
+ */
+type objectReference struct {
+	parent map[interface{}]interface{}
+	key    string
+	kind   string
+}
+
+func newObjectReference(parent interface{}, key string, kind string) []objectReference {
+	parentMap, ok := parent.(map[interface{}]interface{})
+
+	if !ok {
+		return nil
+	}
+
+	if _, ok := parentMap[key].(string); !ok {
+		return nil
+	}
+
+	return []objectReference{{parent: parentMap, key: key, kind: kind}}
+}
+
+func (reference objectReference) Name() string {
+	return reference.parent[reference.key].(string)
+}
+
+/*
+ * Objects reference each other by name. When the referenced object is part
+ * of the rendered objects the reference is rewritten to its env aware name,
+ * so templates don't need {{ context.objects.Kind.name.name }} for them.
+ * References to objects which are not rendered (shared objects) are kept.
+ */
+func injectReferences(injectContext InjectContext, object map[string]interface{}) {
+	for _, reference := range objectReferences(object) {
+		if referenced, ok := injectContext.Objects[reference.kind][reference.Name()]; ok {
+			reference.parent[reference.key] = referenced.Name
+		}
+	}
+}
+
+func objectReferences(object map[string]interface{}) []objectReference {
+	references := podSpecReferences(podTemplateOf(object, "spec"))
+
+	switch object["kind"] {
+	case K8S_POD:
+		references = podSpecReferences(NestedValue(object, "spec"))
+	case K8S_STATEFULSET:
+		references = append(references, newObjectReference(NestedValue(object, "spec"), "serviceName", K8S_SERVICE)...)
+	case K8S_INGRESS:
+		references = ingressReferences(NestedValue(object, "spec"))
+	case K8S_HPA:
+		scaleTargetRef := NestedValue(object, "spec", "scaleTargetRef")
+		references = newObjectReference(scaleTargetRef, "name", NestedString(scaleTargetRef, "kind"))
+	}
+
+	return references
+}
+
+/*
+ * Returns the given field (spec or metadata) of the pod template of a
+ * workload, nil for other objects.
+ */
+func podTemplateOf(object map[string]interface{}, field string) interface{} {
+	switch object["kind"] {
+	case K8S_DEPLOYMENT, K8S_STATEFULSET, K8S_DAEMONSET, K8S_REPLICASET, K8S_JOB:
+		return NestedValue(object, "spec", "template", field)
+	case K8S_CRONJOB:
+		return NestedValue(object, "spec", "jobTemplate", "spec", "template", field)
+	}
+
+	return nil
+}
+
+func podSpecReferences(podSpec interface{}) []objectReference {
+	references := make([]objectReference, 0)
+
+	containers := make([]interface{}, 0)
+	containers = append(containers, NestedSlice(podSpec, "containers")...)
+	containers = append(containers, NestedSlice(podSpec, "initContainers")...)
+
+	for _, container := range containers {
+		for _, envFrom := range NestedSlice(container, "envFrom") {
+			references = append(references, newObjectReference(NestedValue(envFrom, "configMapRef"), "name", K8S_CONFIGMAP)...)
+			references = append(references, newObjectReference(NestedValue(envFrom, "secretRef"), "name", K8S_SECRET)...)
+		}
+
+		for _, env := range NestedSlice(container, "env") {
+			references = append(references, newObjectReference(NestedValue(env, "valueFrom", "configMapKeyRef"), "name", K8S_CONFIGMAP)...)
+			references = append(references, newObjectReference(NestedValue(env, "valueFrom", "secretKeyRef"), "name", K8S_SECRET)...)
+		}
+	}
+
+	for _, volume := range NestedSlice(podSpec, "volumes") {
+		references = append(references, newObjectReference(NestedValue(volume, "persistentVolumeClaim"), "claimName", K8S_PVC)...)
+		references = append(references, newObjectReference(NestedValue(volume, "configMap"), "name", K8S_CONFIGMAP)...)
+		references = append(references, newObjectReference(NestedValue(volume, "secret"), "secretName", K8S_SECRET)...)
+
+		for _, source := range NestedSlice(volume, "projected", "sources") {
+			references = append(references, newObjectReference(NestedValue(source, "configMap"), "name", K8S_CONFIGMAP)...)
+			references = append(references, newObjectReference(NestedValue(source, "secret"), "name", K8S_SECRET)...)
+		}
+	}
+
+	for _, imagePullSecret := range NestedSlice(podSpec, "imagePullSecrets") {
+		references = append(references, newObjectReference(imagePullSecret, "name", K8S_SECRET)...)
+	}
+
+	return references
+}
+
+/*
+ * Supports the backends of networking.k8s.io/v1 (service.name) and of the
+ * older beta apis (serviceName).
+ */
+func ingressReferences(spec interface{}) []objectReference {
+	references := make([]objectReference, 0)
+
+	backends := []interface{}{
+		NestedValue(spec, "defaultBackend"),
+		NestedValue(spec, "backend"),
+	}
+
+	for _, rule := range NestedSlice(spec, "rules") {
+		for _, path := range NestedSlice(rule, "http", "paths") {
+			backends = append(backends, NestedValue(path, "backend"))
+		}
+	}
+
+	for _, backend := range backends {
+		references = append(references, newObjectReference(NestedValue(backend, "service"), "name", K8S_SERVICE)...)
+		references = append(references, newObjectReference(backend, "serviceName", K8S_SERVICE)...)
+	}
+
+	for _, tls := range NestedSlice(spec, "tls") {
+		references = append(references, newObjectReference(tls, "secretName", K8S_SECRET)...)
+	}
+
+	return references
+}
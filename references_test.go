@@ -0,0 +1,181 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const testReferencesTemplate = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+
+---
+
+apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+
+---
+
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+
+---
+
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  selector:
+    app: web
+
+---
+
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: web
+spec:
+  serviceName: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      initContainers:
+        - name: init
+          envFrom:
+            - configMapRef:
+                name: config
+      containers:
+        - name: php
+          envFrom:
+            - secretRef:
+                name: credentials
+            - configMapRef:
+                name: shared-config
+          env:
+            - name: PASSWORD
+              valueFrom:
+                secretKeyRef:
+                  name: credentials
+                  key: password
+      volumes:
+        - name: data
+          persistentVolumeClaim:
+            claimName: data
+        - name: config
+          configMap:
+            name: config
+        - name: credentials
+          secret:
+            secretName: credentials
+
+---
+
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  tls:
+    - secretName: credentials
+  rules:
+    - http:
+        paths:
+          - path: /
+            backend:
+              service:
+                name: web
+          - path: /api
+            backend:
+              service:
+                name: api
+
+---
+
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web
+spec:
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: StatefulSet
+    name: web
+`
+
+func TestInjectReferences(t *testing.T) {
+	var deployerSpec = DeployerSpec{
+		ProjectDir: ".",
+		TagVersion: "test-01",
+		Env:        "feature-1",
+		Branch:     "feature-1",
+		Namespace:  "default",
+	}
+
+	objects, err := UnmarshalYaml(testReferencesTemplate)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var renderContext RenderContext
+	err = renderContext.Build(deployerSpec, objects)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects = InjectMetadata(InjectContext{
+		Objects:    renderContext.Objects,
+		Env:        renderContext.Env,
+		Branch:     renderContext.Branch,
+		Namespace:  renderContext.Namespace,
+		TagVersion: renderContext.DeployerSpec.TagVersion,
+	}, objects)
+
+	assert := assert.New(t)
+
+	statefulSet := objects[4]
+	podSpec := NestedValue(statefulSet, "spec", "template", "spec")
+	container := NestedSlice(podSpec, "containers")[0]
+	volumes := NestedSlice(podSpec, "volumes")
+
+	assert.Equal("feature-1-web", NestedString(statefulSet, "spec", "serviceName"))
+	assert.Equal("feature-1-config", NestedString(NestedSlice(NestedSlice(podSpec, "initContainers")[0], "envFrom")[0], "configMapRef", "name"))
+	assert.Equal("feature-1-credentials", NestedString(NestedSlice(container, "envFrom")[0], "secretRef", "name"))
+	assert.Equal("shared-config", NestedString(NestedSlice(container, "envFrom")[1], "configMapRef", "name"))
+	assert.Equal("feature-1-credentials", NestedString(NestedSlice(container, "env")[0], "valueFrom", "secretKeyRef", "name"))
+	assert.Equal("feature-1-data", NestedString(volumes[0], "persistentVolumeClaim", "claimName"))
+	assert.Equal("feature-1-config", NestedString(volumes[1], "configMap", "name"))
+	assert.Equal("feature-1-credentials", NestedString(volumes[2], "secret", "secretName"))
+
+	ingress := objects[5]
+	paths := NestedSlice(NestedSlice(ingress, "spec", "rules")[0], "http", "paths")
+
+	assert.Equal("feature-1-credentials", NestedString(NestedSlice(ingress, "spec", "tls")[0], "secretName"))
+	assert.Equal("feature-1-web", NestedString(paths[0], "backend", "service", "name"))
+	assert.Equal("api", NestedString(paths[1], "backend", "service", "name"))
+
+	assert.Equal("feature-1-web", NestedString(objects[6], "spec", "scaleTargetRef", "name"))
+}
+
+func TestInjectReferencesKeepsRenderedNames(t *testing.T) {
+	injectContext := InjectContext{
+		Objects: map[string]map[string]RenderContextEnvAwareObject{
+			K8S_SERVICE: {"web": {Name: "feature-1-web"}},
+		},
+	}
+
+	backend := map[interface{}]interface{}{"serviceName": "feature-1-web"}
+	injectReferences(injectContext, map[string]interface{}{"kind": K8S_INGRESS, "spec": map[interface{}]interface{}{"backend": backend}})
+
+	assert.Equal(t, "feature-1-web", backend["serviceName"])
+}
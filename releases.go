@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"sort"
+	"strings"
+	"time"
+)
+
+const RELEASE_LABEL = "kube-deployer"
+const RELEASE_LABEL_VALUE = "release"
+const RELEASE_DEPLOYED_AT_ANNOTATION = DEPLOYED_AT_ANNOTATION
+const RELEASE_MANIFEST_KEY = "manifest"
+const DEFAULT_RELEASE_HISTORY_LIMIT = 10
+const RELEASE_VERSION_HASH_LENGTH = 8
+
+/*
+ * A release is the rendered definition of one version of an env. Releases
+ * are stored as Secrets next to the objects they describe (rendered Secrets
+ * may be part of the manifest) and carry the same env/branch labels as
+ * the objects of the env.
+ */
+type Release struct {
+	Project    string
+	Env        string
+	Branch     string
+	BranchName string
+	Version    string
+	DeployedAt time.Time
+	Manifest   string
+}
+
+func NewRelease(deployerSpec DeployerSpec, manifest string) Release {
+	return Release{
+		Project:    MakeUrlSlug(deployerSpec.Project, DNS_MAX_LENGTH),
+		Env:        MakeUrlSlug(deployerSpec.Env, DNS_MAX_LENGTH),
+		Branch:     MakeUrlSlug(deployerSpec.Branch, DNS_MAX_LENGTH),
+		BranchName: deployerSpec.Branch,
+		Version:    deployerSpec.TagVersion,
+		DeployedAt: time.Now().UTC(),
+		Manifest:   manifest,
+	}
+}
+
+/*
+ * Versions which are not valid in a name are slugged and get a short hash of
+ * the version, so e.g. 1_0 and 1-0 are different releases.
+ */
+func (release Release) Name() string {
+	version := strings.Map(func(char rune) rune {
+		if isAZ09(char) || char == '.' {
+			return char
+		}
+
+		return '-'
+	}, strings.ToLower(release.Version))
+
+	version = strings.TrimRight(version, "-.")
+
+	if version != release.Version {
+		version = strings.TrimLeft(version+"-"+MD5(release.Version)[:RELEASE_VERSION_HASH_LENGTH], "-.")
+	}
+
+	prefix := release.Env
+	if release.Project != "" {
+		prefix += "-" + release.Project
+	}
+
+	if version == "" {
+		return prefix + "-release"
+	}
+
+	return prefix + "-release-" + version
+}
+
+func (release Release) toSecret(namespace string) map[string]interface{} {
+	labels := map[string]interface{}{
+		"env":         release.Env,
+		"branch":      release.Branch,
+		"branch_hash": MD5(release.Branch),
+		"version":     release.Version,
+		RELEASE_LABEL: RELEASE_LABEL_VALUE,
+	}
+
+	if release.Project != "" {
+		labels["project"] = release.Project
+	}
+
+	annotations := map[string]interface{}{
+		RELEASE_DEPLOYED_AT_ANNOTATION: release.DeployedAt.Format(time.RFC3339Nano),
+	}
+
+	if release.BranchName != "" {
+		annotations[BRANCH_ANNOTATION] = release.BranchName
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "Opaque",
+		"metadata": map[string]interface{}{
+			"name":        release.Name(),
+			"namespace":   namespace,
+			"labels":      labels,
+			"annotations": annotations,
+		},
+		"stringData": map[string]interface{}{
+			RELEASE_MANIFEST_KEY: release.Manifest,
+		},
+	}
+}
+
+func releaseFromSecret(secret map[string]interface{}) (Release, error) {
+	name := NestedString(secret, "metadata", "name")
+
+	manifest, err := base64.StdEncoding.DecodeString(NestedString(secret, "data", RELEASE_MANIFEST_KEY))
+
+	if err != nil {
+		return Release{}, fmt.Errorf("release %s: %v", name, err)
+	}
+
+	deployedAt, err := time.Parse(time.RFC3339Nano, NestedString(secret, "metadata", "annotations", RELEASE_DEPLOYED_AT_ANNOTATION))
+
+	if err != nil {
+		return Release{}, fmt.Errorf("release %s: %v", name, err)
+	}
+
+	return Release{
+		Project:    NestedString(secret, "metadata", "labels", "project"),
+		Env:        NestedString(secret, "metadata", "labels", "env"),
+		Branch:     NestedString(secret, "metadata", "labels", "branch"),
+		BranchName: NestedString(secret, "metadata", "annotations", BRANCH_ANNOTATION),
+		Version:    NestedString(secret, "metadata", "labels", "version"),
+		DeployedAt: deployedAt,
+		Manifest:   string(manifest),
+	}, nil
+}
+
+/*
+ * Stores the release and removes the oldest releases of the env beyond the
+ * history limit.
+ */
+func SaveRelease(kubeClient KubeClient, namespace string, release Release) error {
+	template, err := yaml.Marshal(release.toSecret(namespace))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = kubeClient.Apply(string(template), namespace, false)
+
+	if err != nil {
+		return err
+	}
+
+	releases, err := ListReleases(kubeClient, namespace, release.Project, release.Env)
+
+	if err != nil {
+		return err
+	}
+
+	for i := DEFAULT_RELEASE_HISTORY_LIMIT; i < len(releases); i++ {
+		_, err = kubeClient.Delete("v1", "Secret", namespace, releases[i].Name(), false)
+
+		if err != nil && !IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Returns all releases of the env, the most recently deployed first. The
+ * project may be empty.
+ */
+func ListReleases(kubeClient KubeClient, namespace string, project string, env string) ([]Release, error) {
+	selector := map[string]string{
+		"env":         env,
+		RELEASE_LABEL: RELEASE_LABEL_VALUE,
+	}
+
+	if project != "" {
+		selector["project"] = project
+	}
+
+	secrets, err := kubeClient.List("v1", "Secret", namespace, LabelSelector(selector))
+
+	if err != nil {
+		return nil, err
+	}
+
+	releases := make([]Release, 0)
+
+	for _, secret := range secrets {
+		release, err := releaseFromSecret(secret)
+
+		if err != nil {
+			return nil, err
+		}
+
+		releases = append(releases, release)
+	}
+
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].DeployedAt.After(releases[j].DeployedAt)
+	})
+
+	return releases, nil
+}
+
+/*
+ * Picks the release to roll back to. Without a version this is the most
+ * recent release with a version other than the current one.
+ */
+func FindRollbackRelease(releases []Release, toVersion string) (Release, error) {
+	if len(releases) == 0 {
+		return Release{}, errors.New("no releases recorded for this env")
+	}
+
+	for _, release := range releases {
+		if toVersion == "" && release.Version != releases[0].Version {
+			return release, nil
+		}
+
+		if toVersion != "" && release.Version == toVersion {
+			return release, nil
+		}
+	}
+
+	if toVersion == "" {
+		return Release{}, fmt.Errorf("no release before version %s recorded", releases[0].Version)
+	}
+
+	return Release{}, fmt.Errorf("no release of version %s recorded", toVersion)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRollback(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		"app.yml":    testAppTemplate,
+		"config.yml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\ndata:\n  foo: bar\n",
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+	kubeClient := NewFakeKubeClient()
+
+	err := deploy(kubeClient, testDeployerSpec(projectDir, "master"), DeployOptions{})
+	assert.Nil(err)
+
+	deployerSpec := testDeployerSpec(projectDir, "master")
+	deployerSpec.TagVersion = "1.0.43"
+	deployerSpec.Templates = []string{"app.yml", "config.yml"}
+
+	err = deploy(kubeClient, deployerSpec, DeployOptions{})
+	assert.Nil(err)
+	assert.NotNil(kubeClient.Object("staging", "ConfigMap", "master-config"))
+
+	releases, err := ListReleases(kubeClient, "staging", "", "master")
+
+	assert.Nil(err)
+	assert.Len(releases, 2)
+	assert.Equal("1.0.43", releases[0].Version)
+	assert.Equal("master-release-1.0.43", releases[0].Name())
+
+	err = rollback(kubeClient, "staging", "", "master", "", DeployOptions{DryRun: true})
+
+	assert.Nil(err)
+	assert.NotNil(kubeClient.Object("staging", "ConfigMap", "master-config"))
+
+	err = rollback(kubeClient, "staging", "", "master", "", DeployOptions{})
+
+	assert.Nil(err)
+	assert.Nil(kubeClient.Object("staging", "ConfigMap", "master-config"))
+	assert.Equal("42", fakeObjectLabels(kubeClient.Object("staging", "Deployment", "master-web"))["version"])
+
+	releases, err = ListReleases(kubeClient, "staging", "", "master")
+
+	assert.Nil(err)
+	assert.Equal("42", releases[0].Version)
+
+	err = rollback(kubeClient, "staging", "", "master", "1.0.43", DeployOptions{})
+
+	assert.Nil(err)
+	assert.NotNil(kubeClient.Object("staging", "ConfigMap", "master-config"))
+
+	err = rollback(kubeClient, "staging", "", "master", "7", DeployOptions{})
+
+	assert.EqualError(err, "no release of version 7 recorded")
+}
+
+func TestFindRollbackRelease(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+
+	_, err := FindRollbackRelease([]Release{}, "")
+	assert.EqualError(err, "no releases recorded for this env")
+
+	releases := []Release{
+		{Version: "3", DeployedAt: now},
+		{Version: "3", DeployedAt: now.Add(-time.Minute)},
+		{Version: "2", DeployedAt: now.Add(-2 * time.Minute)},
+		{Version: "1", DeployedAt: now.Add(-3 * time.Minute)},
+	}
+
+	release, err := FindRollbackRelease(releases, "")
+	assert.Nil(err)
+	assert.Equal("2", release.Version)
+
+	release, err = FindRollbackRelease(releases, "1")
+	assert.Nil(err)
+	assert.Equal("1", release.Version)
+
+	_, err = FindRollbackRelease(releases[:2], "")
+	assert.EqualError(err, "no release before version 3 recorded")
+}
+
+func TestReleaseName(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("master-release-1.0", Release{Env: "master", Version: "1.0"}.Name())
+	assert.Equal("master-foo-release-1-0", Release{Env: "master", Project: "foo", Version: "1-0"}.Name())
+	assert.Equal("master-release-1-0-"+MD5("1_0")[:8], Release{Env: "master", Version: "1_0"}.Name())
+	assert.Equal("master-release-v1.0-"+MD5("V1.0")[:8], Release{Env: "master", Version: "V1.0"}.Name())
+	assert.Equal("master-release", Release{Env: "master"}.Name())
+	assert.Equal("master-release-"+MD5("+")[:8], Release{Env: "master", Version: "+"}.Name())
+}
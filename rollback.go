@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fatih/color"
+	"gopkg.in/yaml.v2"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ * Live state of the objects of a definition taken right before applying it.
+ */
+type Snapshot struct {
+	// Objects as they were live, without server populated fields
+	Objects []map[string]interface{}
+	// Objects of the definition which did not exist yet
+	Created []map[string]interface{}
+}
+
+func TakeSnapshot(kubeClient KubeClient, definition string, namespace string) (*Snapshot, error) {
+	objects, err := UnmarshalYaml(definition)
+
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{
+		Objects: make([]map[string]interface{}, 0),
+		Created: make([]map[string]interface{}, 0),
+	}
+
+	for _, object := range objects {
+		liveObject, err := kubeClient.Get(
+			NestedString(object, "apiVersion"),
+			NestedString(object, "kind"),
+			namespace,
+			NestedString(object, "metadata", "name"),
+		)
+
+		if IsNotFound(err) {
+			snapshot.Created = append(snapshot.Created, object)
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		snapshot.Objects = append(snapshot.Objects, StripServerFields(liveObject))
+	}
+
+	return snapshot, nil
+}
+
+/*
+ * Versions found in the version label of the snapshotted objects.
+ */
+func (snapshot *Snapshot) Versions() []string {
+	versions := make([]string, 0)
+
+	for _, object := range snapshot.Objects {
+		versions = append(versions, NestedString(object, "metadata", "labels", "version"))
+	}
+
+	versions = Unique(versions)
+	sort.Strings(versions)
+
+	return versions
+}
+
+/*
+ * Applies the snapshotted objects again and removes the objects created since.
+ */
+func (snapshot *Snapshot) Restore(kubeClient KubeClient, namespace string, dryRun bool) (string, error) {
+	output := make([]string, 0)
+
+	if len(snapshot.Objects) > 0 {
+		templates := make([]string, 0)
+
+		for _, object := range snapshot.Objects {
+			template, err := yaml.Marshal(object)
+
+			if err != nil {
+				return "", err
+			}
+
+			templates = append(templates, string(template))
+		}
+
+		applyOutput, err := kubeClient.Apply(strings.Join(templates, "\n---\n"), namespace, dryRun)
+
+		if err != nil {
+			return applyOutput, err
+		}
+
+		output = append(output, applyOutput)
+	}
+
+	for _, object := range snapshot.Created {
+		deleteOutput, err := kubeClient.Delete(
+			NestedString(object, "apiVersion"),
+			NestedString(object, "kind"),
+			namespace,
+			NestedString(object, "metadata", "name"),
+			dryRun,
+		)
+
+		if err != nil && !IsNotFound(err) {
+			return strings.Join(output, "\n"), err
+		}
+
+		if deleteOutput != "" {
+			output = append(output, deleteOutput)
+		}
+	}
+
+	return strings.Join(output, "\n"), nil
+}
+
+func rollbackAfterFailure(kubeClient KubeClient, snapshot *Snapshot, namespace string, deployErr error) error {
+	versions := snapshot.Versions()
+
+	if len(versions) == 0 {
+		fmt.Println("Rolling back: no previous version deployed, removing created objects")
+	} else {
+		fmt.Printf("Rolling back to version %s\n", strings.Join(versions, ", "))
+	}
+
+	output, err := snapshot.Restore(kubeClient, namespace, false)
+
+	if output != "" {
+		fmt.Println(output)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%v\nrollback failed: %v", deployErr, err)
+	}
+
+	return fmt.Errorf("%v\nrolled back to the previously applied objects", deployErr)
+}
+
+/*
+ * Applies the manifest of an earlier release of the env and deletes the
+ * objects the current release has in addition.
+ */
+func rollback(kubeClient KubeClient, namespace string, project string, env string, toVersion string, options DeployOptions) error {
+	releases, err := ListReleases(kubeClient, namespace, project, env)
+
+	if err != nil {
+		return err
+	}
+
+	target, err := FindRollbackRelease(releases, toVersion)
+
+	if err != nil {
+		return err
+	}
+
+	current := releases[0]
+
+	fmt.Printf("Rolling back env %s from version %s to %s\n\n", env, current.Version, target.Version)
+
+	if options.Verbose {
+		color.Yellow(target.Manifest)
+	}
+
+	output, err := kubeClient.Apply(target.Manifest, namespace, options.DryRun)
+
+	if output != "" {
+		color.Green(output)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	removedObjects, err := objectsMissingIn(current.Manifest, target.Manifest)
+
+	if err != nil {
+		return err
+	}
+
+	for _, object := range removedObjects {
+		output, err := kubeClient.Delete(
+			NestedString(object, "apiVersion"),
+			NestedString(object, "kind"),
+			namespace,
+			NestedString(object, "metadata", "name"),
+			options.DryRun,
+		)
+
+		if err != nil && !IsNotFound(err) {
+			return err
+		}
+
+		if output != "" {
+			color.Green(output)
+		}
+	}
+
+	if options.DryRun {
+		return nil
+	}
+
+	if options.Timeout > 0 {
+		err = WaitForRollout(kubeClient, target.Manifest, namespace, options.Timeout)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	target.DeployedAt = time.Now().UTC()
+
+	return SaveRelease(kubeClient, namespace, target)
+}
+
+/*
+ * Returns the objects of the manifest which are not part of the other manifest.
+ */
+func objectsMissingIn(manifest string, otherManifest string) ([]map[string]interface{}, error) {
+	objects, err := UnmarshalYaml(manifest)
+
+	if err != nil {
+		return nil, err
+	}
+
+	otherObjects, err := UnmarshalYaml(otherManifest)
+
+	if err != nil {
+		return nil, err
+	}
+
+	otherKeys := make([]string, 0)
+
+	for _, object := range otherObjects {
+		otherKeys = append(otherKeys, NestedString(object, "kind")+"/"+NestedString(object, "metadata", "name"))
+	}
+
+	missing := make([]map[string]interface{}, 0)
+
+	for _, object := range objects {
+		if !Contains(otherKeys, NestedString(object, "kind")+"/"+NestedString(object, "metadata", "name")) {
+			missing = append(missing, object)
+		}
+	}
+
+	return missing, nil
+}
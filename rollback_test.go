@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDeployRollbackOnFailure(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		"app.yml":    testAppTemplate,
+		"config.yml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\ndata:\n  foo: bar\n",
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+	kubeClient := NewFakeKubeClient()
+	options := DeployOptions{Timeout: 10 * time.Millisecond, RollbackOnFailure: true}
+
+	kubeClient.Statuses["Deployment/master-web"] = map[string]interface{}{
+		"replicas":          1,
+		"updatedReplicas":   1,
+		"availableReplicas": 1,
+	}
+
+	err := deploy(kubeClient, testDeployerSpec(projectDir, "master"), options)
+
+	assert.Nil(err)
+
+	kubeClient.Statuses["Deployment/master-web"] = map[string]interface{}{
+		"conditions": []interface{}{
+			map[string]interface{}{"type": "Progressing", "reason": "ProgressDeadlineExceeded", "message": "timed out progressing"},
+		},
+	}
+
+	deployerSpec := testDeployerSpec(projectDir, "master")
+	deployerSpec.TagVersion = "43"
+	deployerSpec.Templates = []string{"app.yml", "config.yml"}
+
+	err = deploy(kubeClient, deployerSpec, options)
+
+	assert.EqualError(err, "rollout did not complete for 1 object(s):\n  Deployment/master-web failed: timed out progressing\nrolled back to the previously applied objects")
+
+	deployment := kubeClient.Object("staging", "Deployment", "master-web")
+	assert.Equal("42", fakeObjectLabels(deployment)["version"])
+	assert.Equal("foo/bar:42", NestedSlice(deployment, "spec", "template", "spec", "containers")[0].(map[interface{}]interface{})["image"])
+	assert.Nil(NestedValue(deployment, "metadata", "resourceVersion"))
+	assert.Nil(kubeClient.Object("staging", "ConfigMap", "master-config"))
+	assert.NotNil(kubeClient.Object("staging", "Service", "master-web"))
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const DEFAULT_ROLLOUT_TIMEOUT = 5 * time.Minute
+
+var rolloutPollInterval = 2 * time.Second
+
+type RolloutStatus struct {
+	Done    bool
+	Failed  bool
+	Message string
+}
+
+type RolloutResult struct {
+	Kind   string
+	Name   string
+	Status RolloutStatus
+}
+
+/*
+ * Returned by WaitForRollout when at least one object did not converge.
+ */
+type RolloutError struct {
+	Results []RolloutResult
+}
+
+func (err *RolloutError) Error() string {
+	lines := []string{fmt.Sprintf("rollout did not complete for %d object(s):", len(err.Results))}
+
+	for _, result := range err.Results {
+		state := "timed out"
+		if result.Status.Failed {
+			state = "failed"
+		}
+
+		lines = append(lines, fmt.Sprintf("  %s/%s %s: %s", result.Kind, result.Name, state, result.Status.Message))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func IsRolloutKind(kind string) bool {
+	switch kind {
+	case K8S_DEPLOYMENT, K8S_STATEFULSET, K8S_DAEMONSET, K8S_JOB:
+		return true
+	}
+
+	return false
+}
+
+/*
+ * Polls all Deployments, StatefulSets, DaemonSets and Jobs of the definition
+ * until they are available (or complete), failed or the timeout is reached.
+ */
+func WaitForRollout(kubeClient KubeClient, definition string, namespace string, timeout time.Duration) error {
+	objects, err := UnmarshalYaml(definition)
+
+	if err != nil {
+		return err
+	}
+
+	pending := make(map[string]map[string]interface{})
+
+	for _, object := range objects {
+		kind := NestedString(object, "kind")
+
+		if IsRolloutKind(kind) {
+			pending[kind+"/"+NestedString(object, "metadata", "name")] = object
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	statuses := make(map[string]RolloutStatus)
+
+	for {
+		for key, object := range pending {
+			kind := NestedString(object, "kind")
+			name := NestedString(object, "metadata", "name")
+
+			liveObject, err := kubeClient.Get(NestedString(object, "apiVersion"), kind, namespace, name)
+
+			if err != nil {
+				return err
+			}
+
+			status := GetRolloutStatus(kind, liveObject)
+
+			if status.Message != statuses[key].Message {
+				fmt.Printf("%s: %s\n", key, status.Message)
+			}
+
+			statuses[key] = status
+
+			if status.Done || status.Failed {
+				delete(pending, key)
+			}
+		}
+
+		if len(pending) == 0 || time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(rolloutPollInterval)
+	}
+
+	keys := make([]string, 0, len(statuses))
+	for key := range statuses {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	rolloutError := &RolloutError{}
+
+	for _, key := range keys {
+		if statuses[key].Done {
+			continue
+		}
+
+		keyParts := strings.SplitN(key, "/", 2)
+		rolloutError.Results = append(rolloutError.Results, RolloutResult{
+			Kind:   keyParts[0],
+			Name:   keyParts[1],
+			Status: statuses[key],
+		})
+	}
+
+	if len(rolloutError.Results) > 0 {
+		return rolloutError
+	}
+
+	return nil
+}
+
+/*
+ * Mirrors the checks of "kubectl rollout status" and "kubectl wait --for=condition=complete".
+ */
+func GetRolloutStatus(kind string, object map[string]interface{}) RolloutStatus {
+	if kind == K8S_JOB {
+		return getJobStatus(object)
+	}
+
+	generation, _ := NestedInt(object, "metadata", "generation")
+	observedGeneration, _ := NestedInt(object, "status", "observedGeneration")
+
+	if observedGeneration < generation {
+		return RolloutStatus{Message: "Waiting for rollout to be observed"}
+	}
+
+	switch kind {
+	case K8S_DEPLOYMENT:
+		return getDeploymentStatus(object)
+	case K8S_STATEFULSET:
+		return getStatefulSetStatus(object)
+	case K8S_DAEMONSET:
+		return getDaemonSetStatus(object)
+	}
+
+	return RolloutStatus{Done: true}
+}
+
+func getDeploymentStatus(object map[string]interface{}) RolloutStatus {
+	for _, condition := range NestedSlice(object, "status", "conditions") {
+		if NestedString(condition, "type") == "Progressing" && NestedString(condition, "reason") == "ProgressDeadlineExceeded" {
+			return RolloutStatus{Failed: true, Message: NestedString(condition, "message")}
+		}
+	}
+
+	replicas, ok := NestedInt(object, "spec", "replicas")
+	if !ok {
+		replicas = 1
+	}
+
+	statusReplicas, _ := NestedInt(object, "status", "replicas")
+	updatedReplicas, _ := NestedInt(object, "status", "updatedReplicas")
+	availableReplicas, _ := NestedInt(object, "status", "availableReplicas")
+
+	if updatedReplicas < replicas {
+		return RolloutStatus{Message: fmt.Sprintf("%d out of %d new replicas have been updated", updatedReplicas, replicas)}
+	}
+
+	if statusReplicas > updatedReplicas {
+		return RolloutStatus{Message: fmt.Sprintf("%d old replicas are pending termination", statusReplicas-updatedReplicas)}
+	}
+
+	if availableReplicas < updatedReplicas {
+		return RolloutStatus{Message: fmt.Sprintf("%d of %d updated replicas are available", availableReplicas, updatedReplicas)}
+	}
+
+	return RolloutStatus{Done: true, Message: "successfully rolled out"}
+}
+
+func getStatefulSetStatus(object map[string]interface{}) RolloutStatus {
+	if NestedString(object, "spec", "updateStrategy", "type") == "OnDelete" {
+		return RolloutStatus{Done: true, Message: "OnDelete update strategy, not waiting"}
+	}
+
+	replicas, ok := NestedInt(object, "spec", "replicas")
+	if !ok {
+		replicas = 1
+	}
+
+	readyReplicas, _ := NestedInt(object, "status", "readyReplicas")
+	updatedReplicas, _ := NestedInt(object, "status", "updatedReplicas")
+
+	if readyReplicas < replicas {
+		return RolloutStatus{Message: fmt.Sprintf("%d of %d pods are ready", readyReplicas, replicas)}
+	}
+
+	if partition, ok := NestedInt(object, "spec", "updateStrategy", "rollingUpdate", "partition"); ok && partition > 0 {
+		if updatedReplicas < replicas-partition {
+			return RolloutStatus{Message: fmt.Sprintf("%d of %d pods of the partition are updated", updatedReplicas, replicas-partition)}
+		}
+
+		return RolloutStatus{Done: true, Message: "partitioned roll out complete"}
+	}
+
+	if NestedString(object, "status", "currentRevision") != NestedString(object, "status", "updateRevision") {
+		return RolloutStatus{Message: fmt.Sprintf("%d of %d pods are updated", updatedReplicas, replicas)}
+	}
+
+	return RolloutStatus{Done: true, Message: "successfully rolled out"}
+}
+
+func getDaemonSetStatus(object map[string]interface{}) RolloutStatus {
+	if NestedString(object, "spec", "updateStrategy", "type") == "OnDelete" {
+		return RolloutStatus{Done: true, Message: "OnDelete update strategy, not waiting"}
+	}
+
+	desired, _ := NestedInt(object, "status", "desiredNumberScheduled")
+	updated, _ := NestedInt(object, "status", "updatedNumberScheduled")
+	available, _ := NestedInt(object, "status", "numberAvailable")
+
+	if updated < desired {
+		return RolloutStatus{Message: fmt.Sprintf("%d out of %d new pods have been updated", updated, desired)}
+	}
+
+	if available < desired {
+		return RolloutStatus{Message: fmt.Sprintf("%d of %d updated pods are available", available, desired)}
+	}
+
+	return RolloutStatus{Done: true, Message: "successfully rolled out"}
+}
+
+func getJobStatus(object map[string]interface{}) RolloutStatus {
+	for _, condition := range NestedSlice(object, "status", "conditions") {
+		if NestedString(condition, "status") != "True" {
+			continue
+		}
+
+		switch NestedString(condition, "type") {
+		case "Complete":
+			return RolloutStatus{Done: true, Message: "complete"}
+		case "Failed":
+			return RolloutStatus{Failed: true, Message: strings.TrimSpace(NestedString(condition, "reason") + " " + NestedString(condition, "message"))}
+		}
+	}
+
+	succeeded, _ := NestedInt(object, "status", "succeeded")
+
+	completions, ok := NestedInt(object, "spec", "completions")
+	if !ok {
+		completions = 1
+	}
+
+	return RolloutStatus{Message: fmt.Sprintf("%d of %d completions", succeeded, completions)}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGetRolloutStatus(t *testing.T) {
+	testSet := []struct {
+		Kind     string
+		Object   map[string]interface{}
+		Expected RolloutStatus
+	}{
+		{
+			Kind: K8S_DEPLOYMENT,
+			Object: map[string]interface{}{
+				"metadata": map[string]interface{}{"generation": 2.0},
+				"status":   map[string]interface{}{"observedGeneration": 1.0},
+			},
+			Expected: RolloutStatus{Message: "Waiting for rollout to be observed"},
+		},
+		{
+			Kind: K8S_DEPLOYMENT,
+			Object: map[string]interface{}{
+				"spec":   map[string]interface{}{"replicas": 2.0},
+				"status": map[string]interface{}{"replicas": 3.0, "updatedReplicas": 2.0, "availableReplicas": 2.0},
+			},
+			Expected: RolloutStatus{Message: "1 old replicas are pending termination"},
+		},
+		{
+			Kind: K8S_DEPLOYMENT,
+			Object: map[string]interface{}{
+				"spec":   map[string]interface{}{"replicas": 2.0},
+				"status": map[string]interface{}{"replicas": 2.0, "updatedReplicas": 2.0, "availableReplicas": 1.0},
+			},
+			Expected: RolloutStatus{Message: "1 of 2 updated replicas are available"},
+		},
+		{
+			Kind: K8S_DEPLOYMENT,
+			Object: map[string]interface{}{
+				"status": map[string]interface{}{
+					"conditions": []interface{}{
+						map[string]interface{}{"type": "Progressing", "reason": "ProgressDeadlineExceeded", "message": "ReplicaSet \"web\" has timed out progressing."},
+					},
+				},
+			},
+			Expected: RolloutStatus{Failed: true, Message: "ReplicaSet \"web\" has timed out progressing."},
+		},
+		{
+			Kind: K8S_STATEFULSET,
+			Object: map[string]interface{}{
+				"spec":   map[string]interface{}{"replicas": 3.0},
+				"status": map[string]interface{}{"readyReplicas": 3.0, "updatedReplicas": 1.0, "currentRevision": "a", "updateRevision": "b"},
+			},
+			Expected: RolloutStatus{Message: "1 of 3 pods are updated"},
+		},
+		{
+			Kind: K8S_STATEFULSET,
+			Object: map[string]interface{}{
+				"spec":   map[string]interface{}{"replicas": 3.0},
+				"status": map[string]interface{}{"readyReplicas": 3.0, "updatedReplicas": 3.0, "currentRevision": "b", "updateRevision": "b"},
+			},
+			Expected: RolloutStatus{Done: true, Message: "successfully rolled out"},
+		},
+		{
+			Kind: K8S_DAEMONSET,
+			Object: map[string]interface{}{
+				"status": map[string]interface{}{"desiredNumberScheduled": 4.0, "updatedNumberScheduled": 4.0, "numberAvailable": 3.0},
+			},
+			Expected: RolloutStatus{Message: "3 of 4 updated pods are available"},
+		},
+		{
+			Kind: K8S_JOB,
+			Object: map[string]interface{}{
+				"status": map[string]interface{}{
+					"conditions": []interface{}{
+						map[string]interface{}{"type": "Failed", "status": "True", "reason": "BackoffLimitExceeded", "message": "Job has reached the specified backoff limit"},
+					},
+				},
+			},
+			Expected: RolloutStatus{Failed: true, Message: "BackoffLimitExceeded Job has reached the specified backoff limit"},
+		},
+		{
+			Kind: K8S_JOB,
+			Object: map[string]interface{}{
+				"status": map[string]interface{}{
+					"conditions": []interface{}{
+						map[string]interface{}{"type": "Complete", "status": "True"},
+					},
+				},
+			},
+			Expected: RolloutStatus{Done: true, Message: "complete"},
+		},
+	}
+
+	for _, test := range testSet {
+		assert.Equal(t, test.Expected, GetRolloutStatus(test.Kind, test.Object))
+	}
+}
+
+func TestDeployWaitsForRollout(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{"app.yml": testAppTemplate})
+	defer os.RemoveAll(projectDir)
+
+	defer func(interval time.Duration) { rolloutPollInterval = interval }(rolloutPollInterval)
+	rolloutPollInterval = time.Millisecond
+
+	assert := assert.New(t)
+	kubeClient := NewFakeKubeClient()
+
+	err := deploy(kubeClient, testDeployerSpec(projectDir, "master"), DeployOptions{Timeout: 20 * time.Millisecond})
+
+	assert.EqualError(err, "rollout did not complete for 1 object(s):\n  Deployment/master-web timed out: 0 out of 1 new replicas have been updated")
+
+	kubeClient.Statuses["Deployment/master-web"] = map[string]interface{}{
+		"replicas":          1,
+		"updatedReplicas":   1,
+		"availableReplicas": 1,
+	}
+
+	err = deploy(kubeClient, testDeployerSpec(projectDir, "master"), DeployOptions{Timeout: 20 * time.Millisecond})
+
+	assert.Nil(err)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const SECRETS_KEY_ENV = "KUBE_DEPLOYER_SECRETS_KEY"
+const SECRETS_KEY_FILE_ENV = "KUBE_DEPLOYER_SECRETS_KEY_FILE"
+const SECRETS_FILE_VERSION = "v1"
+
+/*
+ * The encrypted secrets file is yaml, so it can be committed and reviewed:
+ *
+ *   kube-deployer-secrets: v1
+ *   data: <base64 of nonce and AES-256-GCM ciphertext>
+ *
+ * The plaintext is yaml as well, one map of keys and values per Secret:
+ *
+ *   db-credentials:
+ *     username: app
+ *     password: s3cret
+ */
+type SecretsFile struct {
+	Version string `yaml:"kube-deployer-secrets"`
+	Data    string `yaml:"data"`
+}
+
+/*
+ * Reads the key from the given file, the KUBE_DEPLOYER_SECRETS_KEY env var
+ * or the file named by KUBE_DEPLOYER_SECRETS_KEY_FILE, in this order. The key
+ * is 32 random bytes, base64 encoded.
+ */
+func ReadSecretsKey(keyFile string) ([]byte, error) {
+	encodedKey := os.Getenv(SECRETS_KEY_ENV)
+
+	if keyFile == "" && encodedKey == "" {
+		keyFile = os.Getenv(SECRETS_KEY_FILE_ENV)
+	}
+
+	if keyFile != "" {
+		content, err := ioutil.ReadFile(keyFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot read secrets key file %s", keyFile)
+		}
+
+		encodedKey = string(content)
+	}
+
+	if encodedKey == "" {
+		return nil, fmt.Errorf("no secrets key, set %s or %s", SECRETS_KEY_ENV, SECRETS_KEY_FILE_ENV)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("secrets key must be 32 bytes, base64 encoded")
+	}
+
+	return key, nil
+}
+
+func EncryptSecrets(key []byte, plaintext []byte) ([]byte, error) {
+	_, err := ParseSecrets(plaintext)
+
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newSecretsCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(SecretsFile{
+		Version: SECRETS_FILE_VERSION,
+		Data:    base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)),
+	})
+}
+
+func DecryptSecrets(key []byte, encrypted []byte) ([]byte, error) {
+	var secretsFile SecretsFile
+	err := yaml.Unmarshal(encrypted, &secretsFile)
+
+	if err != nil || secretsFile.Version != SECRETS_FILE_VERSION {
+		return nil, errors.New("not a kube-deployer secrets file")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(secretsFile.Data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newSecretsCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("secrets file is truncated")
+	}
+
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+
+	if err != nil {
+		return nil, errors.New("cannot decrypt secrets file, wrong key?")
+	}
+
+	return plaintext, nil
+}
+
+func newSecretsCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+/*
+ * Parses decrypted secrets into Secret name -> key -> value. Values are kept
+ * as written, e.g. 0123 or yes stay strings instead of becoming numbers or
+ * booleans.
+ */
+func ParseSecrets(plaintext []byte) (map[string]map[string]string, error) {
+	var parsed map[string]map[string]interface{}
+	err := yaml.Unmarshal(plaintext, &parsed)
+
+	if err != nil {
+		return nil, fmt.Errorf("secrets must be a map of secret names to keys and values: %v", err)
+	}
+
+	for name, data := range parsed {
+		for key, value := range data {
+			switch value.(type) {
+			case map[interface{}]interface{}, []interface{}:
+				return nil, fmt.Errorf("secret %s: value of %s must be a string", name, key)
+			}
+		}
+	}
+
+	var secrets map[string]map[string]string
+	err = yaml.Unmarshal(plaintext, &secrets)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return secrets, nil
+}
+
+/*
+ * Decrypts the secrets file of the target. Returns nil if the target has
+ * none.
+ */
+func (deployerSpec DeployerSpec) ReadSecrets() (map[string]map[string]string, error) {
+	if deployerSpec.SecretsFile == "" {
+		return nil, nil
+	}
+
+	filePath := filepath.Join(deployerSpec.ProjectDir, deployerSpec.SecretsFile)
+	encrypted, err := ioutil.ReadFile(filePath)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read secrets file %s", filePath)
+	}
+
+	key, err := ReadSecretsKey(deployerSpec.SecretsKeyFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := DecryptSecrets(key, encrypted)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filePath, err)
+	}
+
+	return ParseSecrets(plaintext)
+}
+
+/*
+ * One Secret object per entry of the secrets file. They are injected and
+ * referenced like the objects of the templates.
+ */
+func SecretObjects(secrets map[string]map[string]string) []map[string]interface{} {
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	objects := make([]map[string]interface{}, 0)
+
+	for _, name := range names {
+		data := map[interface{}]interface{}{}
+
+		for key, value := range secrets[name] {
+			data[key] = base64.StdEncoding.EncodeToString([]byte(value))
+		}
+
+		objects = append(objects, map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       K8S_SECRET,
+			"type":       "Opaque",
+			"metadata": map[interface{}]interface{}{
+				"name": name,
+			},
+			"data": data,
+		})
+	}
+
+	return objects
+}
+
+/*
+ * Encrypts the plaintext read from in into the file.
+ */
+func encryptSecretsFile(filePath string, keyFile string, in io.Reader) error {
+	key, err := ReadSecretsKey(keyFile)
+
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := ioutil.ReadAll(in)
+
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := EncryptSecrets(key, plaintext)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filePath, encrypted, 0644)
+}
+
+/*
+ * Decrypts the file (if it exists) into a temporary file, opens $EDITOR and
+ * encrypts the result again.
+ */
+func editSecretsFile(filePath string, keyFile string) error {
+	key, err := ReadSecretsKey(keyFile)
+
+	if err != nil {
+		return err
+	}
+
+	plaintext := []byte{}
+	encrypted, err := ioutil.ReadFile(filePath)
+
+	if err == nil {
+		plaintext, err = DecryptSecrets(key, encrypted)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile("", "kube-deployer-secrets-*.yml")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(plaintext)
+	tmpFile.Close()
+
+	if err != nil {
+		return err
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+
+	cmd := exec.Command("sh", "-c", editor+` "$0"`, tmpFile.Name())
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("editor: %v", err)
+	}
+
+	edited, err := os.Open(tmpFile.Name())
+
+	if err != nil {
+		return err
+	}
+
+	defer edited.Close()
+
+	return encryptSecretsFile(filePath, keyFile, edited)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSecretsKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+const testSecretsTemplate = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+        - name: php
+          image: "{{ context.containers.php.name }}"
+          envFrom:
+            - secretRef:
+                name: db-credentials
+          env:
+            - name: API_TOKEN_HASH
+              value: "{{ sha256 context.secrets.api.token }}"
+`
+
+func TestEncryptDecryptSecrets(t *testing.T) {
+	assert := assert.New(t)
+
+	key, _ := base64.StdEncoding.DecodeString(testSecretsKey)
+	plaintext := []byte("db-credentials:\n  password: s3cret\n")
+
+	encrypted, err := EncryptSecrets(key, plaintext)
+	assert.Nil(err)
+	assert.NotContains(string(encrypted), "s3cret")
+	assert.Contains(string(encrypted), "kube-deployer-secrets: v1")
+
+	decrypted, err := DecryptSecrets(key, encrypted)
+	assert.Nil(err)
+	assert.Equal(plaintext, decrypted)
+
+	otherKey := make([]byte, 32)
+	_, err = DecryptSecrets(otherKey, encrypted)
+	assert.EqualError(err, "cannot decrypt secrets file, wrong key?")
+
+	_, err = EncryptSecrets(key, []byte("db-credentials:\n  password: [a, b]\n"))
+	assert.EqualError(err, "secret db-credentials: value of password must be a string")
+}
+
+func TestReadSecretsKey(t *testing.T) {
+	assert := assert.New(t)
+
+	keyFile, err := ioutil.TempFile("", "kube-deployer-key")
+	assert.Nil(err)
+	defer os.Remove(keyFile.Name())
+
+	keyFile.WriteString(testSecretsKey + "\n")
+	keyFile.Close()
+
+	defer setTestEnv(map[string]string{SECRETS_KEY_ENV: "", SECRETS_KEY_FILE_ENV: ""})()
+
+	_, err = ReadSecretsKey("")
+	assert.EqualError(err, "no secrets key, set KUBE_DEPLOYER_SECRETS_KEY or KUBE_DEPLOYER_SECRETS_KEY_FILE")
+
+	key, err := ReadSecretsKey(keyFile.Name())
+	assert.Nil(err)
+	assert.Len(key, 32)
+
+	os.Setenv(SECRETS_KEY_FILE_ENV, keyFile.Name())
+
+	key, err = ReadSecretsKey("")
+	assert.Nil(err)
+	assert.Len(key, 32)
+
+	os.Setenv(SECRETS_KEY_ENV, "c2hvcnQ=")
+
+	_, err = ReadSecretsKey("")
+	assert.EqualError(err, "secrets key must be 32 bytes, base64 encoded")
+}
+
+func TestRenderSecrets(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{"app.yml": testSecretsTemplate})
+	defer os.RemoveAll(projectDir)
+
+	defer setTestEnv(map[string]string{SECRETS_KEY_ENV: testSecretsKey})()
+
+	assert := assert.New(t)
+
+	err := encryptSecretsFile(
+		filepath.Join(projectDir, "secrets.yml"),
+		"",
+		bytes.NewBufferString("db-credentials:\n  username: app\n  password: \"{{ s3&cret\"\napi:\n  token: abc\n"),
+	)
+	assert.Nil(err)
+
+	deployerSpec := testDeployerSpec(projectDir, "master")
+	deployerSpec.SecretsFile = "secrets.yml"
+
+	kubeClient := NewFakeKubeClient()
+	err = deploy(kubeClient, deployerSpec, DeployOptions{})
+	assert.Nil(err)
+
+	secret := kubeClient.Object("staging", "Secret", "master-db-credentials")
+	assert.Equal("master", fakeObjectLabels(secret)["env"])
+	assert.Equal(base64.StdEncoding.EncodeToString([]byte("{{ s3&cret")), NestedString(secret, "data", "password"))
+	assert.NotNil(kubeClient.Object("staging", "Secret", "master-api"))
+
+	container := NestedSlice(kubeClient.Object("staging", "Deployment", "master-web"), "spec", "template", "spec", "containers")[0]
+	assert.Equal("master-db-credentials", NestedString(NestedSlice(container, "envFrom")[0], "secretRef", "name"))
+	assert.Equal(sha256Hex([]byte("abc")), NestedString(NestedSlice(container, "env")[0], "value"))
+
+	os.Setenv(SECRETS_KEY_ENV, base64.StdEncoding.EncodeToString(make([]byte, 32)))
+
+	_, err = render(deployerSpec)
+	assert.EqualError(err, filepath.Join(projectDir, "secrets.yml")+": cannot decrypt secrets file, wrong key?")
+}
+
+func TestParseSecrets(t *testing.T) {
+	assert := assert.New(t)
+
+	secrets, err := ParseSecrets([]byte("db:\n  octal: 0123\n  hex: 0x1F\n  bool: yes\n  float: 1e3\n  empty:\n  big: 123456789012345678901234567890\n  quoted: \"42\"\n"))
+	assert.Nil(err)
+	assert.Equal(map[string]map[string]string{
+		"db": {
+			"octal":  "0123",
+			"hex":    "0x1F",
+			"bool":   "yes",
+			"float":  "1e3",
+			"empty":  "",
+			"big":    "123456789012345678901234567890",
+			"quoted": "42",
+		},
+	}, secrets)
+
+	_, err = ParseSecrets([]byte("db:\n  nested:\n    key: value\n"))
+	assert.EqualError(err, "secret db: value of nested must be a string")
+}
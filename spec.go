@@ -4,14 +4,17 @@ import (
 	"errors"
 	"fmt"
 	"gopkg.in/urfave/cli.v1"
-	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 )
 
 const DEFAULT_DEPLOYER_YAML = ".kube-deploy.yml"
 const DEPLOYER_SPEC_MIN_VERSION = 1
+const DEPLOYER_SPEC_VERSION = 2
+const RENDERING_YAML = "yaml"
+const RENDERING_TEXT = "text"
 
 func (spec *DeployerSpec) FromCliContext(c *cli.Context) error {
 	tag := c.String(TAG_FLAG)
@@ -83,7 +86,21 @@ func (spec *DeployerSpec) FromCliContext(c *cli.Context) error {
 
 	spec.Branch = branch
 
-	return err
+	if err != nil {
+		return err
+	}
+
+	spec.SecretsKeyFile = c.String(SECRETS_KEY_FILE_FLAG)
+
+	if rendering := c.String(RENDERING_FLAG); rendering != "" {
+		spec.Rendering = rendering
+	}
+
+	if spec.Rendering != "" && spec.Rendering != RENDERING_YAML && spec.Rendering != RENDERING_TEXT {
+		return fmt.Errorf("unknown rendering %s, use %s or %s", spec.Rendering, RENDERING_YAML, RENDERING_TEXT)
+	}
+
+	return spec.applyVarFlags(c.StringSlice(VALUES_FLAG), c.StringSlice(SET_FLAG))
 }
 
 func (deployerConfig *DeployerConfigFile) ReadFileFromFile(projectDir string) error {
@@ -94,17 +111,27 @@ func (deployerConfig *DeployerConfigFile) ReadFileFromFile(projectDir string) er
 		return errors.New("Cannot read file " + filePath)
 	}
 
-	err = yaml.Unmarshal(yamlFile, deployerConfig)
+	node, err := ParseConfig(yamlFile)
 
 	if err != nil {
 		return err
 	}
 
-	if deployerConfig.SpecVersion < DEPLOYER_SPEC_MIN_VERSION {
-		return errors.New(".kube-deploy.yml Spec must have at least version 3")
+	return DecodeConfig(node, deployerConfig)
+}
+
+/*
+ * Like ReadFileFromFile, but leaves the config empty if the project dir has
+ * no config file.
+ */
+func (deployerConfig *DeployerConfigFile) ReadOptionalFileFromFile(projectDir string) error {
+	_, err := os.Stat(projectDir + "/" + DEFAULT_DEPLOYER_YAML)
+
+	if os.IsNotExist(err) {
+		return nil
 	}
 
-	return nil
+	return deployerConfig.ReadFileFromFile(projectDir)
 }
 
 func (spec *DeployerSpec) fromFile(
@@ -123,6 +150,10 @@ func (spec *DeployerSpec) fromFile(
 	}
 
 	spec.ProjectDir = projectDir
+	spec.Project = deployerConfig.Project
+	spec.ResourceFilter = deployerConfig.Clean.ResourceFilter()
+	spec.Rendering = deployerConfig.Rendering
+	spec.PartialsDir = deployerConfig.Partials
 	spec.TagVersion = tag
 	spec.Namespace = namespace
 	spec.Env = env
@@ -137,27 +168,37 @@ func (spec *DeployerSpec) fromFile(
 		Host: clusterDefinition.Host,
 	}
 
-	spec.Containers = make([]DeployerSpecContainer, len(deployerConfig.Containers))
-	for _, container := range deployerConfig.Containers {
-		spec.Containers = append(spec.Containers, DeployerSpecContainer{
-			Image: container.Image,
-			Id:    container.Id,
-		})
+	target, namespaceExist := clusterDefinition.Targets[namespace]
+
+	if !namespaceExist {
+		return errors.New(fmt.Sprintf("Namespace %s not present in list of targets", namespace))
 	}
 
-	namespaceExist := false
-	for _, target := range clusterDefinition.Targets {
-		if target.Namespace == namespace {
-			namespaceExist = true
+	// The top level templates are the base set of all targets
+	spec.Templates = append(append([]string{}, deployerConfig.Templates...), target.Templates...)
+	spec.Patches = target.Patches
+	spec.Containers = configContainers(deployerConfig.Containers, target.Containers)
 
-			spec.Templates = target.Templates
-		}
+	spec.Ttl, err = ParseTtl(target.Ttl)
+
+	if err != nil {
+		return fmt.Errorf("target %s: invalid ttl %s", namespace, target.Ttl)
 	}
 
-	if !namespaceExist {
-		return errors.New(fmt.Sprintf("Namespace %s not present in list of targets", namespace))
+	if target.Rendering != "" {
+		spec.Rendering = target.Rendering
 	}
 
+	spec.IngressHostPattern = target.Ingress.Host
+	spec.SecretsFile = target.Secrets
+	spec.Generators = target.Generators
+	spec.ProductionEnvs = target.Ingress.Production
+
+	spec.Vars = MergeVars(
+		MergeVars(normalizeVars(deployerConfig.Vars), normalizeVars(clusterDefinition.Vars)),
+		normalizeVars(target.Vars),
+	)
+
 	return nil
 }
 
@@ -197,16 +238,14 @@ func (spec *DeployerSpec) fromParameters(
 func (spec *DeployerSpec) ParseKubernetesYamlFiles() ([]map[string]interface{}, error) {
 	var objects = make([]map[string]interface{}, 0)
 
-	for _, template := range spec.Templates {
-		filePath := spec.ProjectDir + "/" + template
+	templates, err := spec.ReadTemplateFiles()
 
-		yamlFile, err := ioutil.ReadFile(filePath)
-
-		if err != nil {
-			return nil, errors.New(fmt.Sprintf("Cannot read file %s", filePath))
-		}
+	if err != nil {
+		return nil, err
+	}
 
-		unmarshaledObjects, err := UnmarshalYaml(string(yamlFile))
+	for _, template := range templates {
+		unmarshaledObjects, err := UnmarshalYaml(template)
 
 		if err != nil {
 			return nil, err
@@ -220,15 +259,45 @@ func (spec *DeployerSpec) ParseKubernetesYamlFiles() ([]map[string]interface{},
 	return objects, nil
 }
 
+func (spec *DeployerSpec) ReadTemplateFiles() ([]string, error) {
+	var templates = make([]string, 0)
+
+	for _, template := range spec.Templates {
+		filePath := spec.ProjectDir + "/" + template
+
+		yamlFile, err := ioutil.ReadFile(filePath)
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Cannot read file %s", filePath))
+		}
+
+		templates = append(templates, string(yamlFile))
+	}
+
+	return templates, nil
+}
+
 type DeployerSpec struct {
-	ProjectDir string
-	TagVersion string
-	Env        string
-	Branch     string
-	Namespace  string
-	Containers []DeployerSpecContainer
-	Templates  []string
-	Cluster    DeployerSpecCluster
+	ProjectDir         string
+	Project            string
+	TagVersion         string
+	Env                string
+	Branch             string
+	Namespace          string
+	Containers         []DeployerSpecContainer
+	Templates          []string
+	Cluster            DeployerSpecCluster
+	ResourceFilter     ResourceFilter
+	Ttl                time.Duration
+	IngressHostPattern string
+	ProductionEnvs     []string
+	Vars               map[string]interface{}
+	Rendering          string
+	PartialsDir        string
+	SecretsFile        string
+	SecretsKeyFile     string
+	Generators         []Generator
+	Patches            []Patch
 }
 
 type DeployerSpecCluster struct {
@@ -240,21 +309,65 @@ type DeployerSpecCluster struct {
 type DeployerSpecContainer struct {
 	Id    string
 	Image string
+	// Overrides the tag flag for this container
+	Tag string
 }
 
+/*
+ * The config file in the current spec version, older versions are migrated
+ * to it when read.
+ */
 type DeployerConfigFile struct {
-	SpecVersion int `yaml:"version"`
-	Containers  []struct {
-		Id    string `yaml:"id"`
-		Image string `yaml:"image"`
-	} `yaml:"containers"`
-	Clusters map[string]struct {
-		Host    string                     `yaml:"host"`
-		Targets []DeployerConfigFileTarget `yaml:"targets"`
-	} `yaml:"clusters"`
+	SpecVersion int                                    `yaml:"version"`
+	Project     string                                 `yaml:"project"`
+	Vars        map[string]interface{}                 `yaml:"vars"`
+	Rendering   string                                 `yaml:"rendering"`
+	Partials    string                                 `yaml:"partials"`
+	Templates   []string                               `yaml:"templates"`
+	Containers  map[string]DeployerConfigFileContainer `yaml:"containers"`
+	Clusters    map[string]DeployerConfigFileCluster   `yaml:"clusters"`
+	Clean       DeployerConfigFileClean                `yaml:"clean"`
+}
+
+type DeployerConfigFileContainer struct {
+	Image string `yaml:"image"`
+	Tag   string `yaml:"tag"`
+}
+
+type DeployerConfigFileCluster struct {
+	Host    string                              `yaml:"host"`
+	Vars    map[string]interface{}              `yaml:"vars"`
+	Targets map[string]DeployerConfigFileTarget `yaml:"targets"`
+}
+
+type DeployerConfigFileClean struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	Protect []string `yaml:"protect"`
+}
+
+func (clean DeployerConfigFileClean) ResourceFilter() ResourceFilter {
+	return ResourceFilter{
+		Include: clean.Include,
+		Exclude: clean.Exclude,
+	}
+}
+
+func (clean DeployerConfigFileClean) ProtectRules() (ProtectRules, error) {
+	return NewProtectRules(clean.Protect)
 }
 
 type DeployerConfigFileTarget struct {
-	Namespace string   `yaml:"namespace"`
-	Templates []string `yaml:"templates"`
+	Templates  []string                               `yaml:"templates"`
+	Ttl        string                                 `yaml:"ttl"`
+	Vars       map[string]interface{}                 `yaml:"vars"`
+	Rendering  string                                 `yaml:"rendering"`
+	Containers map[string]DeployerConfigFileContainer `yaml:"containers"`
+	Secrets    string                                 `yaml:"secrets"`
+	Generators []Generator                            `yaml:"generators"`
+	Patches    []Patch                                `yaml:"patches"`
+	Ingress    struct {
+		Host       string   `yaml:"host"`
+		Production []string `yaml:"production"`
+	} `yaml:"ingress"`
 }
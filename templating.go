@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"github.com/aymerick/raymond"
 	"strings"
 )
@@ -11,6 +12,7 @@ func (renderContext *RenderContext) Build(deployerSpec DeployerSpec, objects []m
 	branchSlug := MakeUrlSlug(deployerSpec.Branch, DNS_MAX_LENGTH)
 
 	renderContext.Namespace = deployerSpec.Namespace
+	renderContext.Project = MakeUrlSlug(deployerSpec.Project, DNS_MAX_LENGTH)
 	renderContext.Env = envSlug
 	renderContext.Branch = branchSlug
 	renderContext.Containers = make(map[string]RenderContextContainer)
@@ -21,7 +23,12 @@ func (renderContext *RenderContext) Build(deployerSpec DeployerSpec, objects []m
 			continue
 		}
 
-		containerName := container.Image + ":" + deployerSpec.TagVersion
+		tag := deployerSpec.TagVersion
+		if container.Tag != "" {
+			tag = container.Tag
+		}
+
+		containerName := container.Image + ":" + tag
 		renderContext.Containers[container.Id] = RenderContextContainer{
 			Name:  containerName,
 			Image: containerName,
@@ -32,7 +39,13 @@ func (renderContext *RenderContext) Build(deployerSpec DeployerSpec, objects []m
 		kind := object["kind"].(string)
 		var metadata = object["metadata"].(map[interface{}]interface{})
 		name := metadata["name"].(string)
-		envAwareName, err := buildEnvAwareObjectName(kind, name, renderContext)
+		suffixedName := name
+
+		if suffix, ok := renderContext.NameSuffixes[kind][name]; ok {
+			suffixedName = name + "-" + suffix
+		}
+
+		envAwareName, err := buildEnvAwareObjectName(kind, suffixedName, renderContext)
 
 		if err != nil {
 			return err
@@ -47,6 +60,7 @@ func (renderContext *RenderContext) Build(deployerSpec DeployerSpec, objects []m
 		}
 	}
 
+	renderContext.Vars = normalizeVars(deployerSpec.Vars)
 	renderContext.DeployerSpec = deployerSpec
 
 	return nil
@@ -61,7 +75,23 @@ func (renderContext *RenderContext) Render(templates []string) (string, error) {
 			"context": renderContext,
 		}
 
-		renderedTemplate, err := raymond.Render(template, ctx)
+		tpl, err := raymond.Parse(template)
+
+		if err != nil {
+			return "", err
+		}
+
+		tpl.RegisterPartials(renderContext.Partials)
+
+		helperErrors := []error{}
+		dataFrame := raymond.NewDataFrame()
+		dataFrame.Set(HELPER_ERRORS, &helperErrors)
+
+		renderedTemplate, err := tpl.ExecWith(ctx, dataFrame)
+
+		if err == nil && len(helperErrors) > 0 {
+			err = helperErrors[0]
+		}
 
 		if err != nil {
 			return "", err
@@ -73,6 +103,54 @@ func (renderContext *RenderContext) Render(templates []string) (string, error) {
 	return strings.Join(renderedTemplates, "\n---\n"), nil
 }
 
+/*
+ * Renders the template files before parsing them (text rendering) and builds
+ * the context from the resulting objects. The env aware names of
+ * context.objects are only known after parsing, so the files are rendered
+ * twice: the first pass finds the objects, the second renders with their
+ * names. Additional objects (secrets file, generators) are part of the
+ * context but not rendered.
+ */
+func (renderContext *RenderContext) RenderTemplateFiles(deployerSpec DeployerSpec, additionalObjects []map[string]interface{}) ([]map[string]interface{}, error) {
+	templates, err := deployerSpec.ReadTemplateFiles()
+
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]map[string]interface{}, 0)
+
+	for pass := 0; pass < 2; pass++ {
+		err = renderContext.Build(deployerSpec, objects)
+
+		if err != nil {
+			return nil, err
+		}
+
+		objects = make([]map[string]interface{}, 0)
+
+		for i, template := range templates {
+			renderedTemplate, err := renderContext.Render([]string{template})
+
+			if err != nil {
+				return nil, fmt.Errorf("template %s: %v", deployerSpec.Templates[i], err)
+			}
+
+			templateObjects, err := UnmarshalYaml(renderedTemplate)
+
+			if err != nil {
+				return nil, fmt.Errorf("template %s: %v", deployerSpec.Templates[i], err)
+			}
+
+			objects = append(objects, templateObjects...)
+		}
+
+		objects = append(objects, additionalObjects...)
+	}
+
+	return objects, renderContext.Build(deployerSpec, objects)
+}
+
 func buildEnvAwareObjectName(kind string, objectName string, renderContext *RenderContext) (string, error) {
 	sanitizedObjectName := MakeUrlSlug(objectName, DNS_MAX_LENGTH)
 
@@ -91,6 +169,11 @@ type RenderContext struct {
 	Env          string
 	Branch       string
 	Namespace    string
+	Project      string
+	Vars         map[string]interface{}
+	Partials     map[string]string
+	Secrets      map[string]map[string]string
+	NameSuffixes map[string]map[string]string
 	DeployerSpec DeployerSpec
 }
 
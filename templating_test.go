@@ -1,7 +1,12 @@
 package main
 
 import (
+	"github.com/aymerick/raymond"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -84,3 +89,244 @@ spec:
 
 	assert.Equal(expected, actual, "The two words should be the same.")
 }
+
+func renderHelperTemplate(t *testing.T, template string) string {
+	renderContext := RenderContext{
+		Env:       "feature-1",
+		Namespace: "staging",
+		Containers: map[string]RenderContextContainer{
+			"php": {Name: "foo/bar:42", Image: "foo/bar"},
+		},
+	}
+
+	output, err := renderContext.Render([]string{template})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return output
+}
+
+func TestHelperB64enc(t *testing.T) {
+	assert.Equal(t, "Zm9vL2Jhcjo0Mg==", renderHelperTemplate(t, "{{b64enc context.containers.php.name}}"))
+}
+
+func TestHelperSha256(t *testing.T) {
+	assert.Equal(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", renderHelperTemplate(t, `{{sha256 "foo"}}`))
+}
+
+func TestHelperDefault(t *testing.T) {
+	assert.Equal(t, "feature-1", renderHelperTemplate(t, `{{default context.Env "master"}}`))
+	assert.Equal(t, "master", renderHelperTemplate(t, `{{default context.missing "master"}}`))
+}
+
+func TestHelperUpperLower(t *testing.T) {
+	assert.Equal(t, "FEATURE-1", renderHelperTemplate(t, "{{upper context.Env}}"))
+	assert.Equal(t, "staging", renderHelperTemplate(t, `{{lower "STAGING"}}`))
+}
+
+func TestHelperQuote(t *testing.T) {
+	assert.Equal(t, `"it's \"quoted\""`, renderHelperTemplate(t, `{{quote 'it\'s "quoted"'}}`))
+}
+
+func TestHelperToJson(t *testing.T) {
+	assert.Equal(t, `{"php":{"Name":"foo/bar:42","Image":"foo/bar"}}`, renderHelperTemplate(t, "{{toJson context.containers}}"))
+	assert.Equal(t, `"a\u003cb"`, renderHelperTemplate(t, `{{toJson "a<b"}}`))
+}
+
+func TestHelperIndent(t *testing.T) {
+	output, err := raymond.Render("key:\n{{indent 4 text}}", map[string]string{"text": "line 1\nline 2"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "key:\n    line 1\n    line 2", output)
+}
+
+func TestHelperReplace(t *testing.T) {
+	assert.Equal(t, "feature_1", renderHelperTemplate(t, `{{replace "-" "_" context.Env}}`))
+}
+
+func TestHelperEqNe(t *testing.T) {
+	assert.Equal(t, "yes", renderHelperTemplate(t, `{{#if (eq context.Env "feature-1")}}yes{{else}}no{{/if}}`))
+	assert.Equal(t, "no", renderHelperTemplate(t, `{{#if (ne context.Env "feature-1")}}yes{{else}}no{{/if}}`))
+	assert.Equal(t, "yes", renderHelperTemplate(t, `{{#if (ne context.Env "master")}}yes{{/if}}`))
+}
+
+func TestHelperEnv(t *testing.T) {
+	os.Setenv("KUBE_DEPLOYER_TEST", "value")
+	defer os.Unsetenv("KUBE_DEPLOYER_TEST")
+
+	assert.Equal(t, "value", renderHelperTemplate(t, `{{getenv "KUBE_DEPLOYER_TEST"}}`))
+	assert.Equal(t, "", renderHelperTemplate(t, `{{getenv "KUBE_DEPLOYER_MISSING"}}`))
+}
+
+func TestHelperArithmetic(t *testing.T) {
+	assert.Equal(t, "5", renderHelperTemplate(t, `{{add 2 3}}`))
+	assert.Equal(t, "-1", renderHelperTemplate(t, `{{sub 2 "3"}}`))
+	assert.Equal(t, "14", renderHelperTemplate(t, `{{add 2 (mul 3 4)}}`))
+	assert.Equal(t, "3", renderHelperTemplate(t, `{{div 7 2}}`))
+	assert.Equal(t, "1", renderHelperTemplate(t, `{{mod 7 2}}`))
+}
+
+func TestHelperErrors(t *testing.T) {
+	renderContext := RenderContext{
+		Vars: map[string]interface{}{"ratio": math.NaN()},
+	}
+
+	_, err := renderContext.Render([]string{`{{div 1 0}}`})
+	assert.EqualError(t, err, "div: division by zero")
+
+	_, err = renderContext.Render([]string{`{{add 1 "one"}}`})
+	assert.EqualError(t, err, `add: "1" and "one" must be integers`)
+
+	_, err = renderContext.Render([]string{`{{toJson context.vars.ratio}}`})
+	assert.EqualError(t, err, "toJson: json: unsupported value: NaN")
+}
+
+const testTextTemplate = `
+{{#each context.vars.workers}}
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker-{{ this }}
+spec:
+  replicas: {{ ../context.vars.replicas }}
+  template:
+    metadata:
+      labels:
+        app: worker-{{ this }}
+    spec:
+      containers:
+        - name: php
+          image: {{ ../context.containers.php.name }}
+          env:
+            - name: WEB_HOST
+              value: {{ ../context.objects.Service.web.name }}
+{{/each}}
+{{#if (eq context.Env "master")}}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  selector:
+    app: web
+{{/if}}
+`
+
+func TestRenderTextTemplates(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{"app.yml": testTextTemplate})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+
+	deployerSpec := testDeployerSpec(projectDir, "master")
+	deployerSpec.Rendering = RENDERING_TEXT
+	deployerSpec.Vars = map[string]interface{}{
+		"replicas": 2,
+		"workers":  []interface{}{"mail", "pdf"},
+	}
+
+	definition, err := render(deployerSpec)
+	assert.Nil(err)
+
+	objects, err := UnmarshalYaml(definition)
+	assert.Nil(err)
+	assert.Len(objects, 3)
+
+	assert.Equal("master-worker-mail", NestedString(objects[0], "metadata", "name"))
+	assert.Equal("master-worker-pdf", NestedString(objects[1], "metadata", "name"))
+	assert.Equal("master-web", NestedString(objects[2], "metadata", "name"))
+
+	replicas, _ := NestedInt(objects[0], "spec", "replicas")
+	assert.Equal(2, replicas)
+
+	container := NestedSlice(objects[0], "spec", "template", "spec", "containers")[0]
+	assert.Equal("foo/bar:42", NestedString(container, "image"))
+	assert.Equal("master-web", NestedString(NestedSlice(container, "env")[0], "value"))
+
+	deployerSpec.Env = "feature-1"
+
+	definition, err = render(deployerSpec)
+	assert.Nil(err)
+
+	objects, err = UnmarshalYaml(definition)
+	assert.Nil(err)
+	assert.Len(objects, 2)
+	assert.Equal("feature-1-worker-mail", NestedString(objects[0], "metadata", "name"))
+}
+
+func TestRenderTextTemplatesNamesTemplate(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{"app.yml": "kind: Service\n{{#if}}"})
+	defer os.RemoveAll(projectDir)
+
+	deployerSpec := testDeployerSpec(projectDir, "master")
+	deployerSpec.Rendering = RENDERING_TEXT
+
+	_, err := render(deployerSpec)
+
+	assert.Contains(t, err.Error(), "template app.yml: ")
+}
+
+func TestRenderPartials(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		"partials/probes/java.yml": "livenessProbe:\n  httpGet:\n    path: /health\n    port: {{ port }}\n",
+		"app.yml": `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+        - name: php
+          image: {{ context.containers.php.name }}
+          {{> probes/java port=8080 }}
+`,
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+
+	deployerSpec := testDeployerSpec(projectDir, "master")
+	deployerSpec.Rendering = RENDERING_TEXT
+	deployerSpec.PartialsDir = "partials"
+
+	definition, err := render(deployerSpec)
+	assert.Nil(err)
+
+	objects, err := UnmarshalYaml(definition)
+	assert.Nil(err)
+
+	container := NestedSlice(objects[0], "spec", "template", "spec", "containers")[0]
+	port, _ := NestedInt(container, "livenessProbe", "httpGet", "port")
+	assert.Equal(8080, port)
+	assert.Equal("/health", NestedString(container, "livenessProbe", "httpGet", "path"))
+}
+
+func TestRenderPartialNotFound(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		"partials/resources.yml": "{{> limits }}",
+		"app.yml":                "kind: Service\n{{> probes/go }}\n",
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+
+	deployerSpec := testDeployerSpec(projectDir, "master")
+	deployerSpec.PartialsDir = "partials"
+
+	_, err := render(deployerSpec)
+	assert.EqualError(err, "partial resources: partial limits not found")
+
+	ioutil.WriteFile(filepath.Join(projectDir, "partials/limits.yml"), []byte("limits: {}"), 0644)
+
+	_, err = render(deployerSpec)
+	assert.EqualError(err, "template app.yml: partial probes/go not found")
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aymerick/raymond"
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const VALIDATE_TAG = "validate"
+const VALIDATE_ENV = "validate"
+
+var errorLine = regexp.MustCompile(`(?:Parse error on )?line (\d+):?\s*`)
+var documentSeparator = regexp.MustCompile(`(?m:^---$)`)
+
+/*
+ * A problem of the config file or a file it references. Line is 0 if the
+ * problem has no location.
+ */
+type ValidationError struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (validationError ValidationError) Error() string {
+	if validationError.Line == 0 {
+		return fmt.Sprintf("%s: %s", validationError.File, validationError.Message)
+	}
+
+	return fmt.Sprintf("%s:%d: %s", validationError.File, validationError.Line, validationError.Message)
+}
+
+type configValidator struct {
+	projectDir string
+	config     DeployerConfigFile
+	errors     []ValidationError
+}
+
+/*
+ * Checks the config file of the project strictly: unknown keys, required
+ * fields and referenced files. Every target without such problems is
+ * rendered, its errors are reported at the target.
+ */
+func ValidateProject(projectDir string, secretsKeyFile string) []ValidationError {
+	validator := configValidator{projectDir: projectDir}
+
+	content, err := ioutil.ReadFile(filepath.Join(projectDir, DEFAULT_DEPLOYER_YAML))
+
+	if err != nil {
+		validator.add(0, "cannot read file")
+		return validator.errors
+	}
+
+	var root yaml3.Node
+	err = yaml3.Unmarshal(content, &root)
+
+	if err != nil {
+		validator.add(lineOfError(err))
+		return validator.errors
+	}
+
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml3.MappingNode {
+		validator.add(0, "config must be a map")
+		return validator.errors
+	}
+
+	node := root.Content[0]
+
+	if err := checkConfigVersion(configVersion(node)); err != nil {
+		validator.add(lineOf(node, "version"), fmt.Sprintf("version must be at least %d", DEPLOYER_SPEC_MIN_VERSION))
+		return validator.errors
+	}
+
+	// Older versions are validated in their migrated form, the nodes keep
+	// the lines of the file
+	err = MigrateConfig(node)
+
+	if err != nil {
+		validator.add(lineOfError(err))
+		return validator.errors
+	}
+
+	validator.checkFields(node, reflect.TypeOf(validator.config))
+	// Decoded like deploy decodes it
+	err = DecodeConfig(node, &validator.config)
+
+	if typeError, ok := err.(*yaml.TypeError); ok {
+		for _, message := range typeError.Errors {
+			validator.add(lineOfError(fmt.Errorf("%s", message)))
+		}
+	} else if err != nil {
+		validator.add(lineOfError(err))
+	}
+
+	if len(validator.errors) > 0 {
+		return validator.errors
+	}
+
+	validator.validateConfig(node, secretsKeyFile)
+
+	return validator.errors
+}
+
+/*
+ * Reports keys of mapping nodes without a field in the decoded type.
+ */
+func (validator *configValidator) checkFields(node *yaml3.Node, valueType reflect.Type) {
+	switch valueType.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml3.MappingNode {
+			return
+		}
+
+		fields := map[string]reflect.Type{}
+
+		for i := 0; i < valueType.NumField(); i++ {
+			field := valueType.Field(i)
+			fields[strings.Split(field.Tag.Get("yaml"), ",")[0]] = field.Type
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			fieldType, ok := fields[key.Value]
+
+			if !ok && valueType.Name() != "" {
+				validator.add(key.Line, fmt.Sprintf("field %s not found in type %s", key.Value, valueType))
+			} else if !ok {
+				validator.add(key.Line, fmt.Sprintf("field %s not found", key.Value))
+			} else {
+				validator.checkFields(node.Content[i+1], fieldType)
+			}
+		}
+	case reflect.Map:
+		if node.Kind == yaml3.MappingNode {
+			for i := 1; i < len(node.Content); i += 2 {
+				validator.checkFields(node.Content[i], valueType.Elem())
+			}
+		}
+	case reflect.Slice:
+		if node.Kind == yaml3.SequenceNode {
+			for _, item := range node.Content {
+				validator.checkFields(item, valueType.Elem())
+			}
+		}
+	}
+}
+
+func (validator *configValidator) add(line int, message string) {
+	validator.errors = append(validator.errors, ValidationError{
+		File:    DEFAULT_DEPLOYER_YAML,
+		Line:    line,
+		Message: message,
+	})
+}
+
+func (validator *configValidator) validateConfig(node *yaml3.Node, secretsKeyFile string) {
+	config := validator.config
+
+	if config.Rendering != "" && config.Rendering != RENDERING_YAML && config.Rendering != RENDERING_TEXT {
+		validator.add(lineOf(node, "rendering"), fmt.Sprintf("unknown rendering %s, use %s or %s", config.Rendering, RENDERING_YAML, RENDERING_TEXT))
+	}
+
+	if config.Partials != "" && !validator.exists(config.Partials) {
+		validator.add(lineOf(node, "partials"), fmt.Sprintf("partials dir %s not found", config.Partials))
+	}
+
+	if _, err := config.Clean.ProtectRules(); err != nil {
+		validator.add(lineOf(node, "clean", "protect"), err.Error())
+	}
+
+	validator.validateContainers(valueOf(node, "containers"), config.Containers)
+
+	for i, template := range config.Templates {
+		if !validator.exists(template) {
+			validator.add(itemOf(valueOf(node, "templates"), i).Line, fmt.Sprintf("template %s not found", template))
+		}
+	}
+
+	// Rendering targets is pointless while the shared config has problems
+	configValid := len(validator.errors) == 0
+	clustersNode := valueOf(node, "clusters")
+
+	if len(config.Clusters) == 0 {
+		validator.add(lineOf(node, "clusters"), "at least one cluster is required")
+		return
+	}
+
+	// Templates are checked once, even if several targets use them
+	checkedTemplates := map[string]bool{}
+
+	for i := 0; i+1 < len(clustersNode.Content); i += 2 {
+		clusterName := clustersNode.Content[i].Value
+		clusterNode := clustersNode.Content[i+1]
+		cluster := config.Clusters[clusterName]
+
+		if cluster.Host == "" {
+			validator.add(clustersNode.Content[i].Line, fmt.Sprintf("cluster %s: host is required", clusterName))
+		}
+
+		if len(cluster.Targets) == 0 {
+			validator.add(clustersNode.Content[i].Line, fmt.Sprintf("cluster %s: at least one target is required", clusterName))
+		}
+
+		targetsNode := valueOf(clusterNode, "targets")
+
+		for j := 0; targetsNode != nil && j+1 < len(targetsNode.Content); j += 2 {
+			namespace := targetsNode.Content[j].Value
+			errorCount := len(validator.errors)
+
+			validator.validateTarget(targetsNode.Content[j], targetsNode.Content[j+1], namespace, cluster.Targets[namespace], checkedTemplates)
+
+			if configValid && len(validator.errors) == errorCount {
+				validator.renderTarget(targetsNode.Content[j].Line, clusterName, namespace, secretsKeyFile)
+			}
+		}
+	}
+}
+
+/*
+ * Every container needs an image, containers of a target may take it from
+ * the top level container and only override the tag.
+ */
+func (validator *configValidator) validateContainers(node *yaml3.Node, containers map[string]DeployerConfigFileContainer) {
+	for i := 0; node != nil && i+1 < len(node.Content); i += 2 {
+		id := node.Content[i].Value
+
+		if containers[id].Image == "" && validator.config.Containers[id].Image == "" {
+			validator.add(node.Content[i].Line, fmt.Sprintf("container %s: image is required", id))
+		}
+	}
+}
+
+func (validator *configValidator) validateTarget(keyNode *yaml3.Node, node *yaml3.Node, namespace string, target DeployerConfigFileTarget, checkedTemplates map[string]bool) {
+	if namespace == "" {
+		validator.add(keyNode.Line, "target: namespace is required")
+	}
+
+	templates := append(append([]string{}, validator.config.Templates...), target.Templates...)
+
+	if len(templates) == 0 {
+		validator.add(keyNode.Line, fmt.Sprintf("target %s: at least one template is required", namespace))
+	}
+
+	for i, template := range target.Templates {
+		line := itemOf(valueOf(node, "templates"), i).Line
+
+		if !validator.exists(template) {
+			validator.add(line, fmt.Sprintf("template %s not found", template))
+		}
+	}
+
+	rendering := validator.config.Rendering
+	if target.Rendering != "" {
+		rendering = target.Rendering
+	}
+
+	for _, template := range templates {
+		if validator.exists(template) && !checkedTemplates[template] {
+			checkedTemplates[template] = true
+			validator.validateTemplate(template, rendering)
+		}
+	}
+
+	if _, err := ParseTtl(target.Ttl); err != nil {
+		validator.add(lineOf(node, "ttl"), fmt.Sprintf("invalid ttl %s", target.Ttl))
+	}
+
+	if target.Rendering != "" && target.Rendering != RENDERING_YAML && target.Rendering != RENDERING_TEXT {
+		validator.add(lineOf(node, "rendering"), fmt.Sprintf("unknown rendering %s, use %s or %s", target.Rendering, RENDERING_YAML, RENDERING_TEXT))
+	}
+
+	validator.validateContainers(valueOf(node, "containers"), target.Containers)
+
+	if target.Secrets != "" && !validator.exists(target.Secrets) {
+		validator.add(lineOf(node, "secrets"), fmt.Sprintf("secrets file %s not found", target.Secrets))
+	}
+
+	for i, patch := range target.Patches {
+		patchNode := itemOf(valueOf(node, "patches"), i)
+
+		if !validator.exists(patch.File) {
+			validator.add(patchNode.Line, fmt.Sprintf("patch %s not found", patch.File))
+		}
+
+		if patch.Type == PATCH_TYPE_JSON && (patch.Target.Kind == "" || patch.Target.Name == "") {
+			validator.add(patchNode.Line, fmt.Sprintf("patch %s: json patches require target kind and name", patch.File))
+		}
+	}
+
+	for i, generator := range target.Generators {
+		generatorNode := itemOf(valueOf(node, "generators"), i)
+
+		if generator.Kind != K8S_CONFIGMAP && generator.Kind != K8S_SECRET {
+			validator.add(generatorNode.Line, fmt.Sprintf("generator %s: kind must be %s or %s", generator.Name, K8S_CONFIGMAP, K8S_SECRET))
+		}
+
+		if generator.Name == "" {
+			validator.add(generatorNode.Line, "generator: name is required")
+		}
+	}
+}
+
+/*
+ * Reports syntax errors at the line of the template file. Templates
+ * rendered as text are only complete yaml after rendering, the handlebars
+ * syntax is checked instead.
+ */
+func (validator *configValidator) validateTemplate(template string, rendering string) {
+	content, err := ioutil.ReadFile(filepath.Join(validator.projectDir, template))
+
+	if err != nil {
+		validator.errors = append(validator.errors, ValidationError{File: template, Message: "cannot read file"})
+		return
+	}
+
+	if rendering == RENDERING_TEXT {
+		if _, err := raymond.Parse(string(content)); err != nil {
+			line, message := lineOfError(err)
+			validator.errors = append(validator.errors, ValidationError{File: template, Line: line, Message: message})
+		}
+
+		return
+	}
+
+	offset := 0
+
+	for _, document := range documentSeparator.Split(string(content), -1) {
+		var object map[string]interface{}
+		err := yaml3.Unmarshal([]byte(document), &object)
+
+		if err != nil {
+			line, message := lineOfError(err)
+			validator.errors = append(validator.errors, ValidationError{File: template, Line: offset + line, Message: message})
+		} else if object != nil && (NestedString(object, "kind") == "" || NestedString(object, "metadata", "name") == "") {
+			line := offset + 1 + len(document) - len(strings.TrimLeft(document, "\n")) // first non empty line
+			validator.errors = append(validator.errors, ValidationError{File: template, Line: line, Message: "kind and metadata.name are required"})
+		}
+
+		offset += strings.Count(document, "\n")
+	}
+}
+
+func (validator *configValidator) renderTarget(line int, cluster string, namespace string, secretsKeyFile string) {
+	var deployerSpec DeployerSpec
+
+	err := deployerSpec.fromFile(validator.projectDir, VALIDATE_TAG, cluster, VALIDATE_ENV, namespace)
+
+	if err == nil {
+		deployerSpec.Branch = VALIDATE_ENV
+		deployerSpec.SecretsKeyFile = secretsKeyFile
+		_, err = render(deployerSpec)
+	}
+
+	if err != nil {
+		validator.add(line, fmt.Sprintf("target %s: %v", namespace, err))
+	}
+}
+
+func (validator *configValidator) exists(file string) bool {
+	_, err := os.Stat(filepath.Join(validator.projectDir, file))
+
+	return err == nil
+}
+
+/*
+ * Returns the value node of the key path in mapping nodes, nil if missing.
+ */
+func valueOf(node *yaml3.Node, keys ...string) *yaml3.Node {
+	for _, key := range keys {
+		if node == nil || node.Kind != yaml3.MappingNode {
+			return nil
+		}
+
+		var value *yaml3.Node
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				value = node.Content[i+1]
+			}
+		}
+
+		node = value
+	}
+
+	return node
+}
+
+func itemOf(node *yaml3.Node, index int) *yaml3.Node {
+	if node == nil || node.Kind != yaml3.SequenceNode || index >= len(node.Content) {
+		return &yaml3.Node{}
+	}
+
+	return node.Content[index]
+}
+
+/*
+ * Returns the line of the key path, or of the closest existing parent.
+ */
+func lineOf(node *yaml3.Node, keys ...string) int {
+	line := node.Line
+
+	for i := range keys {
+		if value := valueOf(node, keys[:i+1]...); value != nil {
+			line = value.Line
+		}
+	}
+
+	return line
+}
+
+/*
+ * Splits "yaml: line 3: message" and handlebars "Parse error on line 3:"
+ * errors into line and message.
+ */
+func lineOfError(err error) (int, string) {
+	message := strings.TrimPrefix(err.Error(), "yaml: ")
+	match := errorLine.FindStringSubmatchIndex(message)
+
+	if match == nil {
+		return 0, message
+	}
+
+	line, _ := strconv.Atoi(message[match[2]:match[3]])
+
+	return line, strings.TrimSpace(message[:match[0]] + message[match[1]:])
+}
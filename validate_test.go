@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testValidConfig = `version: 1
+containers:
+  - id: php
+    image: foo/bar
+clusters:
+  de:
+    host: https://kube.example.com
+    targets:
+      - namespace: staging
+        templates:
+          - app.yml
+`
+
+func validationMessages(validationErrors []ValidationError) []string {
+	messages := make([]string, 0)
+
+	for _, validationError := range validationErrors {
+		messages = append(messages, validationError.Error())
+	}
+
+	return messages
+}
+
+func TestValidateProject(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		DEFAULT_DEPLOYER_YAML: testValidConfig,
+		"app.yml":             testAppTemplate,
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert.Empty(t, ValidateProject(projectDir, ""))
+}
+
+func TestValidateProjectUnknownKeys(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		DEFAULT_DEPLOYER_YAML: testValidConfig + "        tll: 7d\n",
+		"app.yml":             testAppTemplate,
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert.Equal(t, []string{
+		".kube-deploy.yml:12: field tll not found in type main.DeployerConfigFileTarget",
+	}, validationMessages(ValidateProject(projectDir, "")))
+}
+
+func TestValidateProjectTypeErrors(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		DEFAULT_DEPLOYER_YAML: testValidConfig + "        ttl: [7d]\n" + "        generators:\n          - kind: ConfigMap\n            files: nginx.conf\n",
+		"app.yml":             testAppTemplate,
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert.Equal(t, []string{
+		".kube-deploy.yml:12: cannot unmarshal !!seq into string",
+		".kube-deploy.yml:15: cannot unmarshal !!str `nginx.conf` into []string",
+	}, validationMessages(ValidateProject(projectDir, "")))
+}
+
+func TestValidateProjectErrors(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		DEFAULT_DEPLOYER_YAML: `version: 1
+clusters:
+  de:
+    targets:
+      - namespace: staging
+        templates:
+          - app.yml
+          - missing.yml
+        ttl: forever
+`,
+		"app.yml": "kind: Service\nmetadata:\n  name: web\n---\nkind: Deployment\nspec:\n  replicas: 1\n  selector: [\n",
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert.Equal(t, []string{
+		".kube-deploy.yml:3: cluster de: host is required",
+		".kube-deploy.yml:8: template missing.yml not found",
+		"app.yml:8: did not find expected node content",
+		".kube-deploy.yml:9: invalid ttl forever",
+	}, validationMessages(ValidateProject(projectDir, "")))
+}
+
+func TestValidateProjectVersion(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		DEFAULT_DEPLOYER_YAML: "project: foo\nversion: 0\n",
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert.Equal(t, []string{
+		".kube-deploy.yml:2: version must be at least 1",
+	}, validationMessages(ValidateProject(projectDir, "")))
+}
+
+func TestValidateProjectVersion2(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		DEFAULT_DEPLOYER_YAML: `version: 2
+containers:
+  php:
+    image: foo/bar
+clusters:
+  de:
+    host: https://kube.example.com
+    targets:
+      staging:
+        templates:
+          - app.yml
+        containers:
+          php:
+            tag: stable
+          nginx:
+            tag: "1.25"
+            port: 80
+`,
+		"app.yml": testAppTemplate,
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert.Equal(t, []string{
+		".kube-deploy.yml:17: field port not found in type main.DeployerConfigFileContainer",
+	}, validationMessages(ValidateProject(projectDir, "")))
+
+	err := ioutil.WriteFile(filepath.Join(projectDir, DEFAULT_DEPLOYER_YAML), []byte(`version: 2
+clusters:
+  de:
+    host: https://kube.example.com
+    targets:
+      staging:
+        templates:
+          - app.yml
+        containers:
+          nginx:
+            tag: "1.25"
+`), 0644)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		".kube-deploy.yml:10: container nginx: image is required",
+	}, validationMessages(ValidateProject(projectDir, "")))
+}
+
+func TestValidateProjectRendersTargets(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		DEFAULT_DEPLOYER_YAML: testValidConfig + "        patches:\n          - file: patch.yml\n",
+		"app.yml":             testAppTemplate,
+		"patch.yml":           "kind: Deployment\nmetadata:\n  name: api\n",
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert.Equal(t, []string{
+		".kube-deploy.yml:9: target staging: patch patch.yml: Deployment/api not found",
+	}, validationMessages(ValidateProject(projectDir, "")))
+}
+
+func TestLineOfError(t *testing.T) {
+	line, message := lineOfError(os.ErrNotExist)
+	assert.Equal(t, 0, line)
+	assert.Equal(t, "file does not exist", message)
+
+	line, message = lineOfError(errors.New("yaml: line 4: mapping values are not allowed in this context"))
+	assert.Equal(t, 4, line)
+	assert.Equal(t, "mapping values are not allowed in this context", message)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Template variables, exposed as context.vars. Later sources override
+ * earlier ones: vars of the config file, of the cluster, of the target,
+ * -values files in the given order and finally -set flags. Maps are merged
+ * key by key, all other values are replaced.
+ */
+func MergeVars(base map[string]interface{}, override map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(override))
+
+	for key, value := range base {
+		merged[key] = value
+	}
+
+	for key, value := range override {
+		baseMap, baseIsMap := merged[key].(map[string]interface{})
+		overrideMap, overrideIsMap := value.(map[string]interface{})
+
+		if baseIsMap && overrideIsMap {
+			merged[key] = MergeVars(baseMap, overrideMap)
+		} else {
+			merged[key] = value
+		}
+	}
+
+	return merged
+}
+
+/*
+ * Vars read from yaml have map[interface{}]interface{} maps.
+ */
+func normalizeVars(vars map[string]interface{}) map[string]interface{} {
+	if vars == nil {
+		return map[string]interface{}{}
+	}
+
+	return NormalizeObject(vars)
+}
+
+func ReadValuesFile(filePath string) (map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(filePath)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read values file %s", filePath)
+	}
+
+	var vars map[string]interface{}
+	err = yaml.Unmarshal(content, &vars)
+
+	if err != nil {
+		return nil, fmt.Errorf("values file %s: %v", filePath, err)
+	}
+
+	return normalizeVars(vars), nil
+}
+
+/*
+ * Parses a -set flag like replicas=3 or ingress.host=foo.example.com into
+ * vars. Only true, false and canonical integers are converted, everything
+ * else stays a string, e.g. a tag 1.10 or 0123 is not turned into a number.
+ */
+func ParseSetVar(assignment string) (map[string]interface{}, error) {
+	parts := strings.SplitN(assignment, "=", 2)
+
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid set flag %s, expected key=value", assignment)
+	}
+
+	var value interface{} = parts[1]
+
+	if parts[1] == "true" || parts[1] == "false" {
+		value = parts[1] == "true"
+	} else if number, err := strconv.Atoi(parts[1]); err == nil && strconv.Itoa(number) == parts[1] {
+		value = number
+	}
+
+	keys := strings.Split(parts[0], ".")
+	vars := map[string]interface{}{keys[len(keys)-1]: value}
+
+	for i := len(keys) - 2; i >= 0; i-- {
+		vars = map[string]interface{}{keys[i]: vars}
+	}
+
+	return vars, nil
+}
+
+func (spec *DeployerSpec) applyVarFlags(valuesFiles []string, setVars []string) error {
+	vars := normalizeVars(spec.Vars)
+
+	for _, valuesFile := range valuesFiles {
+		values, err := ReadValuesFile(valuesFile)
+
+		if err != nil {
+			return err
+		}
+
+		vars = MergeVars(vars, values)
+	}
+
+	for _, assignment := range setVars {
+		setVar, err := ParseSetVar(assignment)
+
+		if err != nil {
+			return err
+		}
+
+		vars = MergeVars(vars, setVar)
+	}
+
+	spec.Vars = vars
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testVarsConfig = `
+version: 1
+vars:
+  replicas: 1
+  ingress:
+    host: example.com
+    tls: true
+  features: [a]
+clusters:
+  de_cluster:
+    host: https://foo.k8s.bar.io
+    vars:
+      ingress:
+        host: de.example.com
+    targets:
+      - namespace: staging
+        vars:
+          replicas: 2
+        templates:
+          - app.yml
+`
+
+func TestVarsFromConfig(t *testing.T) {
+	projectDir := writeTestProject(t, map[string]string{
+		DEFAULT_DEPLOYER_YAML: testVarsConfig,
+		"app.yml":             testAppTemplate,
+		"values.yml":          "replicas: 3\nfeatures: [b, c]\n",
+	})
+	defer os.RemoveAll(projectDir)
+
+	assert := assert.New(t)
+
+	var deployerSpec DeployerSpec
+	err := deployerSpec.fromFile(projectDir, "42", "de_cluster", "master", "staging")
+	assert.Nil(err)
+
+	assert.Equal(map[string]interface{}{
+		"replicas": 2,
+		"ingress": map[string]interface{}{
+			"host": "de.example.com",
+			"tls":  true,
+		},
+		"features": []interface{}{"a"},
+	}, deployerSpec.Vars)
+
+	err = deployerSpec.applyVarFlags(
+		[]string{filepath.Join(projectDir, "values.yml")},
+		[]string{"replicas=4", "ingress.host=feature-1.example.com", "ingress.path=/api"},
+	)
+	assert.Nil(err)
+
+	assert.Equal(map[string]interface{}{
+		"replicas": 4,
+		"ingress": map[string]interface{}{
+			"host": "feature-1.example.com",
+			"tls":  true,
+			"path": "/api",
+		},
+		"features": []interface{}{"b", "c"},
+	}, deployerSpec.Vars)
+
+	var renderContext RenderContext
+	err = renderContext.Build(deployerSpec, []map[string]interface{}{})
+	assert.Nil(err)
+
+	output, err := renderContext.Render([]string{"{{ context.vars.ingress.host }}:{{ context.vars.replicas }}"})
+	assert.Nil(err)
+	assert.Equal("feature-1.example.com:4", output)
+}
+
+func TestParseSetVar(t *testing.T) {
+	assert := assert.New(t)
+
+	vars, err := ParseSetVar("debug=true")
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{"debug": true}, vars)
+
+	vars, err = ParseSetVar("replicas=3")
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{"replicas": 3}, vars)
+
+	for _, value := range []string{"1.10", "0123", "on", "yes", "1e3", "0x1F", "+1", ""} {
+		vars, err = ParseSetVar("tag=" + value)
+		assert.Nil(err)
+		assert.Equal(map[string]interface{}{"tag": value}, vars)
+	}
+
+	vars, err = ParseSetVar("tag=[1.2]")
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{"tag": "[1.2]"}, vars)
+
+	vars, err = ParseSetVar("url=http://a.example.com/?b=c")
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{"url": "http://a.example.com/?b=c"}, vars)
+
+	_, err = ParseSetVar("replicas")
+	assert.EqualError(err, "invalid set flag replicas, expected key=value")
+}